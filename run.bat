@@ -1,4 +1,4 @@
 @echo off
 echo Starting server ...
 cd src
-go run scraper.go main.go tree.go treebidir.go bfs.go dfs.go bidirection.go multiplebidirection.go
\ No newline at end of file
+go run scraper.go main.go tree.go treebidir.go bfs.go dfs.go bidirection.go multiplebidirection.go enumerate.go options.go cost.go kbest.go dag.go plan.go reverse.go closure.go index.go cache.go batch.go combined.go diagnose.go verify.go response.go openapi.go elements.go resolve.go stream.go
\ No newline at end of file
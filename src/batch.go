@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*** PARALLEL BATCH SEARCH ***/
+
+const (
+	defaultBatchWorkers = 4
+	maxBatchWorkers     = 16
+)
+
+// nodeMemo holds finished subtrees by element. Single DFS and BFS searches
+// look an element up before expanding it, so the searches of one batch reuse
+// what earlier targets already built. No other search reads it: multiple
+// searches keep every tree of an element, not one, and the rest build no
+// subtrees by element. It is safe for concurrent use; stored nodes are never
+// changed afterwards.
+type nodeMemo struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+}
+
+func newNodeMemo() *nodeMemo {
+	return &nodeMemo{nodes: make(map[string]*Node)}
+}
+
+func (m *nodeMemo) get(element string) (*Node, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[element]
+	return node, ok
+}
+
+func (m *nodeMemo) put(element string, node *Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[element]; !ok {
+		m.nodes[element] = node
+	}
+}
+
+// splicesAncestor reports whether a memoized subtree holds an element of path
+// below its root. Another search built it, so spliced under path that element
+// would become its own ancestor.
+func splicesAncestor(node *Node, path map[string]bool) bool {
+	for element := range path {
+		for _, recipe := range node.combinations {
+			if containsElement(recipe.ingredient1, element, nil) || containsElement(recipe.ingredient2, element, nil) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BatchRequest runs the search described by the embedded SearchRequest for
+// every target. Its target field is ignored.
+type BatchRequest struct {
+	SearchRequest
+	Targets []string `json:"targets"`
+	Workers int      `json:"workers"` // default 4, at most 16
+}
+
+// BatchResult is one line of the batch stream, sent as soon as its target is
+// done. Result has the shape /api/search answers with; Error is set instead
+// when the target could not be searched.
+type BatchResult struct {
+	Index         int         `json:"index"` // position of Target in the request
+	Target        string      `json:"target"`
+	Result        interface{} `json:"result,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	Code          string      `json:"code,omitempty"` // SearchError code of Error
+	ExecutionTime float64     `json:"executionTime"`
+}
+
+// searchBatch searches every target of req on a pool of workers and calls emit
+// from a single goroutine, in the order the targets finish. All targets share
+// one nodeMemo, which only single BFS and DFS searches use.
+func searchBatch(req BatchRequest, emit func(BatchResult)) {
+	workers := req.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	workers = min(min(workers, maxBatchWorkers), len(req.Targets))
+
+	opts := newSearchOptions(req.SearchRequest)
+	opts.memo = newNodeMemo()
+
+	jobs := make(chan int)
+	results := make(chan BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results <- searchBatchTarget(req, index, opts)
+			}
+		}()
+	}
+	go func() {
+		for index := range req.Targets {
+			jobs <- index
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		emit(result)
+	}
+}
+
+func searchBatchTarget(req BatchRequest, index int, opts SearchOptions) (result BatchResult) {
+	target := req.Targets[index]
+	result = BatchResult{Index: index, Target: target}
+	startTime := time.Now()
+	defer func() {
+		// one broken target must not take the whole batch down
+		if r := recover(); r != nil {
+			log.Printf("Batch search for %s panicked: %v\n", target, r)
+			result.Result = nil
+			result.Error = fmt.Sprintf("search failed: %v", r)
+		}
+		result.ExecutionTime = float64(time.Since(startTime).Milliseconds())
+	}()
+
+	search := req.SearchRequest
+	search.Target = target
+	found, err := runSearch(search, opts)
+	if err != nil {
+		_, resp := searchErrorResponse(err)
+		result.Error, result.Code = resp.Error, resp.Code
+		return result
+	}
+	found.dag = search.Output == "dag"
+	found.plan = search.Plan
+	result.Result = found.response(search, float64(time.Since(startTime).Milliseconds()))
+	return result
+}
+
+// batchHandler serves POST /api/batch. The response is newline delimited
+// JSON: one BatchResult per line, flushed as each target finishes.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "POST, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid input"}`, http.StatusBadRequest)
+		log.Printf("Failed to decode batch request: %v\n", err)
+		return
+	}
+	req.spellSettings()
+	if err := costModeError(req.SearchRequest); err != nil {
+		writeSearchError(w, err)
+		return
+	}
+
+	log.Printf("Batch searching %d targets using algorithm: %s, mode: %s\n",
+		len(req.Targets), req.Algorithm, req.SearchMode)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	searchBatch(req, func(result BatchResult) {
+		if err := encoder.Encode(result); err != nil {
+			log.Printf("Failed to write batch result: %v\n", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
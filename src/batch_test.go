@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+// TestMemoSplicesNoAncestor plants a memoized Egg made with Chicken, as a
+// search with other recipes could have left it. Searching Chicken must not
+// splice it in, or Chicken would be its own ancestor.
+func TestMemoSplicesNoAncestor(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	searches := []struct {
+		name   string
+		search func(opts SearchOptions) *Tree
+	}{
+		{"BFS", func(opts SearchOptions) *Tree {
+			tree, _ := searchBFSOne("Chicken", opts)
+			return tree
+		}},
+		{"DFS", func(opts SearchOptions) *Tree {
+			tree, _ := searchDFSOne("Chicken", opts)
+			return tree
+		}},
+	}
+	for _, s := range searches {
+		memo := newNodeMemo()
+		memo.put("Egg", &Node{element: "Egg", combinations: []Recipe{{
+			ingredient1: &Node{element: "Chicken"},
+			ingredient2: &Node{element: "Water"},
+		}}})
+		opts := SearchOptions{memo: memo}
+
+		tree := s.search(opts)
+		if tree == nil {
+			t.Errorf("%s: no tree", s.name)
+			continue
+		}
+		if problems := verifyTree(convertToTreeNode(tree.root), opts); len(problems) > 0 {
+			t.Errorf("%s: %s %v", s.name, formatTree(convertToTreeNode(tree.root)), problems)
+		}
+	}
+}
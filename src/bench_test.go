@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write the benchmark report of recipes.json with:
+//
+//	go test -run TestBenchReport -bench-report bench.md
+var benchReport = flag.String("bench-report", "", "benchmark the search algorithms on recipes.json and write the report to this file (.csv or .md)")
+
+const (
+	benchPerTier    = 2 // targets benchmarked per tier
+	benchMaxRecipes = 3 // maxRecipes of the multiple searches
+)
+
+// benchTarget is one element of the benchmark set.
+type benchTarget struct {
+	Tier    int
+	Element string
+}
+
+// benchTargets picks the first benchPerTier elements of every tier by name, so
+// the set only changes when the dataset does.
+func benchTargets() []benchTarget {
+	byTier := make(map[int][]string)
+	for element, info := range searchIndex.elements {
+		if info.Tier > 0 {
+			byTier[info.Tier] = append(byTier[info.Tier], element)
+		}
+	}
+	var targets []benchTarget
+	for tier := 1; len(byTier) > 0; tier++ {
+		elements := byTier[tier]
+		delete(byTier, tier)
+		sort.Strings(elements)
+		for _, element := range elements[:min(len(elements), benchPerTier)] {
+			targets = append(targets, benchTarget{Tier: tier, Element: element})
+		}
+	}
+	return targets
+}
+
+// benchAlgorithm runs one search the way dispatchSearch does and reports its
+// trees and the nodesVisited values it returned.
+type benchAlgorithm struct {
+	Name string
+	Run  func(target string) ([]*TreeNode, []int)
+}
+
+var benchAlgorithms = []benchAlgorithm{
+	{"BFS/single", func(target string) ([]*TreeNode, []int) {
+		tree, visited := searchBFSOne(target, SearchOptions{})
+		return []*TreeNode{convertToTreeNode(tree.root)}, []int{visited}
+	}},
+	{"DFS/single", func(target string) ([]*TreeNode, []int) {
+		tree, visited := searchDFSOne(target, SearchOptions{})
+		if tree == nil {
+			return nil, []int{visited}
+		}
+		return []*TreeNode{convertToTreeNode(tree.root)}, []int{visited}
+	}},
+	{"Bidirectional/single", func(target string) ([]*TreeNode, []int) {
+		tree, visited := searchBidirectOne(target, SearchOptions{})
+		return []*TreeNode{convertToTreeNode2(tree)}, []int{visited}
+	}},
+	{"BFS/multiple", func(target string) ([]*TreeNode, []int) {
+		trees, visited := searchBFSMultiple(target, benchMaxRecipes, SearchOptions{})
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNodes = append(treeNodes, convertToTreeNode(tree.root))
+		}
+		return treeNodes, visited
+	}},
+	{"DFS/multiple", func(target string) ([]*TreeNode, []int) {
+		trees, visited := searchDFSMultiple(target, benchMaxRecipes, SearchOptions{})
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNodes = append(treeNodes, convertToTreeNode(tree.root))
+		}
+		return treeNodes, visited
+	}},
+	{"Bidirectional/multiple", func(target string) ([]*TreeNode, []int) {
+		trees, visited := searchBidirectionMultiple(target, benchMaxRecipes, SearchOptions{})
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNodes = append(treeNodes, convertToTreeNode2(tree))
+		}
+		return treeNodes, []int{visited}
+	}},
+}
+
+// benchStats is what one search of a target returns: the nodesVisited values
+// summed, the number of trees and their nodes summed.
+type benchStats struct {
+	NodesVisited int
+	Trees        int
+	TreeNodes    int
+}
+
+func (a benchAlgorithm) stats(target string) benchStats {
+	trees, visited := a.Run(target)
+	var stats benchStats
+	for _, v := range visited {
+		stats.NodesVisited += v
+	}
+	for _, tree := range trees {
+		if tree != nil {
+			stats.Trees++
+			stats.TreeNodes += countTreeNodes(tree)
+		}
+	}
+	return stats
+}
+
+// benchRow is one line of the report.
+type benchRow struct {
+	Algorithm string
+	benchTarget
+	benchStats
+	NsPerOp     int64
+	AllocsPerOp int64
+	BytesPerOp  int64
+}
+
+// runBenchReport benchmarks every algorithm on benchTargets with
+// testing.Benchmark and writes the report to filename, as CSV when it ends in
+// .csv and as a markdown table otherwise.
+func runBenchReport(filename string) error {
+	var rows []benchRow
+	for _, algorithm := range benchAlgorithms {
+		for _, target := range benchTargets() {
+			log.Printf("Benchmarking %s on %s (tier %d)\n", algorithm.Name, target.Element, target.Tier)
+			result := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					algorithm.Run(target.Element)
+				}
+			})
+			rows = append(rows, benchRow{
+				Algorithm:   algorithm.Name,
+				benchTarget: target,
+				benchStats:  algorithm.stats(target.Element),
+				NsPerOp:     result.NsPerOp(),
+				AllocsPerOp: result.AllocsPerOp(),
+				BytesPerOp:  result.AllocedBytesPerOp(),
+			})
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		err = writeBenchCSV(file, rows)
+	} else {
+		err = writeBenchMarkdown(file, rows)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %d benchmark results to %s\n", len(rows), filename)
+	return nil
+}
+
+var benchColumns = []string{"algorithm", "tier", "target", "ms/op", "allocs/op", "bytes/op", "nodesVisited", "trees", "treeNodes"}
+
+func (r benchRow) fields() []string {
+	return []string{
+		r.Algorithm,
+		fmt.Sprint(r.Tier),
+		r.Element,
+		fmt.Sprintf("%.3f", float64(r.NsPerOp)/float64(time.Millisecond)),
+		fmt.Sprint(r.AllocsPerOp),
+		fmt.Sprint(r.BytesPerOp),
+		fmt.Sprint(r.NodesVisited),
+		fmt.Sprint(r.Trees),
+		fmt.Sprint(r.TreeNodes),
+	}
+}
+
+func writeBenchCSV(w io.Writer, rows []benchRow) error {
+	writer := csv.NewWriter(w)
+	writer.Write(benchColumns)
+	for _, row := range rows {
+		writer.Write(row.fields())
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeBenchMarkdown(w io.Writer, rows []benchRow) error {
+	fmt.Fprintf(w, "Benchmarked %s with %s on %s/%s, maxRecipes %d for the multiple searches.\n\n",
+		time.Now().Format("2006-01-02"), runtime.Version(), runtime.GOOS, runtime.GOARCH, benchMaxRecipes)
+	fmt.Fprintf(w, "| %s |\n", strings.Join(benchColumns, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(benchColumns)))
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(row.fields(), " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestBenchReport writes the report of -bench-report, and is skipped without
+// it.
+func TestBenchReport(t *testing.T) {
+	if *benchReport == "" {
+		t.Skip("no -bench-report file given")
+	}
+	loadRecipes("recipes.json")
+	if err := runBenchReport(*benchReport); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkAlgorithms runs every algorithm on the benchmark set of the frozen
+// recipes snapshot, reporting nodesVisited and the size of the result next to
+// the time and allocations. TestBenchReport tabulates the same runs.
+func BenchmarkAlgorithms(b *testing.B) {
+	loadDataset(b, "testdata/recipes.json.gz")
+	for _, algorithm := range benchAlgorithms {
+		for _, target := range benchTargets() {
+			b.Run(fmt.Sprintf("%s/tier%d/%s", algorithm.Name, target.Tier, target.Element), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					algorithm.Run(target.Element)
+				}
+				stats := algorithm.stats(target.Element)
+				b.ReportMetric(float64(stats.NodesVisited), "visited/op")
+				b.ReportMetric(float64(stats.TreeNodes), "treenodes/op")
+			})
+		}
+	}
+}
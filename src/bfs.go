@@ -1,52 +1,60 @@
 package main
 
-import (
-	"container/list"
-	"fmt"
-	"sync"
-	"context"
-	"time"
-)
-
 /*** SINGLE RECIPE BFS ***/
 
-var memoSB map[string]*Node
-var mainDataBFS map[string][][]string
-var visitedBFS map[string]bool
+// bfsSearch is the state of one single recipe BFS
+type bfsSearch struct {
+	memo    *nodeMemo
+	recipes map[string][][]string
+	visited map[string]bool
+}
 
-func searchBFSOne(target string) (*Tree, int) {
-	fmt.Println("start bfs single")
-	memoSB = make(map[string]*Node)
-	mainDataBFS = recipeData.Recipes[target]
-	visitedBFS = make(map[string]bool)
+func searchBFSOne(target string, opts SearchOptions) (*Tree, int) {
+	s := &bfsSearch{
+		memo:    opts.nodeMemo(),
+		recipes: opts.recipesFor(target),
+		visited: make(map[string]bool),
+	}
 
-	result, cntNode := bfsOne(target)
+	result, cntNode := s.bfsOne(target, opts)
 
 	return &Tree{root: result}, cntNode
 }
 
-func bfsOne(element string) (*Node, int) {
+func (s *bfsSearch) bfsOne(element string, opts SearchOptions) (*Node, int) {
 	cntNode := 0
 
 	pendingNodes := make(map[string][][]string)
 	nodeMap := make(map[string]*Node)
+	spliced := make(map[string]bool) // the element's subtree holds a memoized one
 
 	queue := []string{element}
-	visitedBFS[element] = true
+	s.visited[element] = true
+	depth := map[string]int{element: 0}
+	opts.events.emit(SearchEvent{Type: eventPush, Element: element})
 
-	for len(queue) > 0 {
+	for len(queue) > 0 && !opts.cancelled() {
 		current := queue[0]
 		queue = queue[1:]
+		opts.events.emit(SearchEvent{Type: eventPop, Element: current, Depth: depth[current]})
+
+		// an element another search already built needs no expanding
+		if node, ok := s.memo.get(current); ok {
+			nodeMap[current] = node
+			spliced[current] = true
+			continue
+		}
 
 		if _, exists := nodeMap[current]; !exists {
 			nodeMap[current] = &Node{element: current}
 		}
 
-		if isBase(current) {
+		if opts.isLeaf(current) {
 			continue
 		}
 
-		if recipes, hasRecipe := mainDataBFS[current]; hasRecipe && len(recipes) > 0 {
+		opts.events.emit(SearchEvent{Type: eventVisit, Element: current, Depth: depth[current]})
+		if recipes, hasRecipe := s.recipes[current]; hasRecipe && len(recipes) > 0 {
 			for _, pair := range recipes {
 				if len(pair) != 2 {
 					continue
@@ -55,28 +63,67 @@ func bfsOne(element string) (*Node, int) {
 
 				for _, ingredient := range pair {
 					cntNode++
-					if !visitedBFS[ingredient] {
-						visitedBFS[ingredient] = true
+					if !s.visited[ingredient] {
+						s.visited[ingredient] = true
+						depth[ingredient] = depth[current] + 1
 						queue = append(queue, ingredient)
+						opts.events.emit(SearchEvent{Type: eventPush, Element: ingredient, Parent: current, Depth: depth[ingredient]})
 					}
 				}
 			}
 		}
 	}
 
-	for el, allPairs := range pendingNodes {
-		firstPair := allPairs[0]
-		ing1 := nodeMap[firstPair[0]]
-		ing2 := nodeMap[firstPair[1]]
+	// a cancelled search leaves elements in the queue that have no node yet
+	if opts.cancelled() {
+		return nil, cntNode
+	}
 
-		nodeMap[el].combinations = []Recipe{
-			{
-				ingredient1: ing1,
-				ingredient2: ing2,
-			},
+	// every element takes its first recipe whose ingredients can be made
+	// without needing the element again, so the tree never loops. Memoized
+	// subtrees come from other searches and are checked against the path.
+	resolved := make(map[string]bool)
+	rejected := false
+	var resolve func(el string, path map[string]bool) bool
+	resolve = func(el string, path map[string]bool) bool {
+		allPairs, pending := pendingNodes[el]
+		if resolved[el] || !pending {
+			// a leaf or a memoized subtree; an element without recipes can't be made
+			made := resolved[el] || opts.isLeaf(el) || len(nodeMap[el].combinations) > 0
+			if made && spliced[el] && splicesAncestor(nodeMap[el], path) {
+				rejected = true
+				return false
+			}
+			return made
+		}
+		if path[el] {
+			return false
 		}
+		path[el] = true
+		defer delete(path, el)
 
-		memoSB[el] = nodeMap[el]
+		for _, pair := range shortestFirst(el, allPairs) {
+			if !resolve(pair[0], path) || !resolve(pair[1], path) {
+				continue
+			}
+			nodeMap[el].combinations = []Recipe{
+				{
+					ingredient1: nodeMap[pair[0]],
+					ingredient2: nodeMap[pair[1]],
+				},
+			}
+			resolved[el] = true
+			spliced[el] = spliced[pair[0]] || spliced[pair[1]]
+			s.memo.put(el, nodeMap[el])
+			return true
+		}
+		return false
+	}
+	if !resolve(element, make(map[string]bool)) && rejected {
+		// the memo was in the way: search again on this search's own subtrees
+		s.memo, s.visited = newNodeMemo(), make(map[string]bool)
+		node, more := s.bfsOne(element, opts)
+		return node, cntNode + more
 	}
 
 	return nodeMap[element], cntNode
@@ -84,16 +131,12 @@ func bfsOne(element string) (*Node, int) {
 
 
 /*** MULTIPLE RECIPE BFS ***/
-var allFinalTargetTrees []*Node
-
-func searchBFSMultiple(target string, maxPathsToReturn int) ([]*Tree, []int) {
-	fmt.Println("start bfs multiple")
-	targetSpecificRecipes := recipeData.Recipes[target]
-	rootNodes := bfsAll(target, maxPathsToReturn, targetSpecificRecipes)
+func searchBFSMultiple(target string, maxPathsToReturn int, opts SearchOptions) ([]*Tree, []int) {
+	targetSpecificRecipes := opts.recipesFor(target)
+	rootNodes := bfsAll(target, maxPathsToReturn, targetSpecificRecipes, opts)
 
 	var trees []*Tree
 	var pathElementCounts []int
-	allFinalTargetTrees = make([]*Node, 0)
 
 	for _, rootNode := range rootNodes {
 		trees = append(trees, &Tree{root: rootNode})
@@ -103,10 +146,7 @@ func searchBFSMultiple(target string, maxPathsToReturn int) ([]*Tree, []int) {
 }
 
 
-func bfsAll(targetElement string, maxPathsToReturn int, currentRecipeMap map[string][][]string) []*Node {
-	var collectedTrees []*Node
-	var mu sync.Mutex 
-
+func bfsAll(targetElement string, maxPathsToReturn int, currentRecipeMap map[string][][]string, opts SearchOptions) []*Node {
 	targetTopLevelCombs, exists := currentRecipeMap[targetElement]
 	if !exists || len(targetTopLevelCombs) == 0 {
 		return []*Node{{element: targetElement}}
@@ -114,204 +154,99 @@ func bfsAll(targetElement string, maxPathsToReturn int, currentRecipeMap map[str
 
 	concurrencyLimit := 8 
 	sem := make(chan struct{}, concurrencyLimit)
-	resultChanBufferSize := 100
-	if maxPathsToReturn > 0 && maxPathsToReturn < resultChanBufferSize {
-		resultChanBufferSize = maxPathsToReturn
-	}
-	resultChan := make(chan *Node, resultChanBufferSize)
-	
-	var wg sync.WaitGroup
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	pathsFound := 0
+	var group searchGroup
 
-	for _, topRecipePair := range targetTopLevelCombs {
-		if len(topRecipePair) != 2 {
+	// every goroutine fills the slot of its recipe, so the trees come in
+	// recipe order however the goroutines are scheduled
+	treesPerRecipe := make([][]*Node, len(targetTopLevelCombs))
+	for i, topRecipePair := range targetTopLevelCombs {
+		if len(topRecipePair) != 2 || findRecipe(targetTopLevelCombs[:i], topRecipePair[0], topRecipePair[1]) != nil {
 			continue
 		}
 
 		sem <- struct{}{} 
-		wg.Add(1)
-		go func(recipe []string, currentContext context.Context) {
-			defer func() {
-				<-sem 
-				wg.Done()
-			}()
-
-			// goroutineMemo := make(map[string][]*Node)
+		group.spawn(func() {
+			defer func() { <-sem }()
+
+			goroutineMemo := make(map[string][]*Node)
 			pathVisited := make(map[string]bool)
 			pathVisited[targetElement] = true
 
-			ing1Name := recipe[0]
-			ing2Name := recipe[1]
+			ing1Name := topRecipePair[0]
+			ing2Name := topRecipePair[1]
 
-			expandedIng1Nodes := expandElementParallel(ing1Name, currentRecipeMap)
-			expandedIng2Nodes := expandElementParallel(ing2Name, currentRecipeMap)
-			
-			delete(pathVisited, targetElement)
+			expandedIng1Nodes := expandElement(ing1Name, currentRecipeMap, pathVisited, goroutineMemo, maxPathsToReturn, opts)
+			expandedIng2Nodes := expandElement(ing2Name, currentRecipeMap, pathVisited, goroutineMemo, maxPathsToReturn, opts)
 
-			if len(expandedIng1Nodes) == 0 { expandedIng1Nodes = []*Node{{element: ing1Name}} }
-			if len(expandedIng2Nodes) == 0 { expandedIng2Nodes = []*Node{{element: ing2Name}} }
+			delete(pathVisited, targetElement)
 
 			for _, nodeIng1 := range expandedIng1Nodes {
 				for _, nodeIng2 := range expandedIng2Nodes {
-					select {
-					case <-currentContext.Done():
+					if maxPathsToReturn > 0 && len(treesPerRecipe[i]) >= maxPathsToReturn {
 						return
-					default:
-						// continue
 					}
-
-					rootNode := &Node{
+					if containsElement(nodeIng1, targetElement, nil) || containsElement(nodeIng2, targetElement, nil) {
+						continue
+					}
+					treesPerRecipe[i] = append(treesPerRecipe[i], &Node{
 						element: targetElement,
 						combinations: []Recipe{{
 							ingredient1: nodeIng1,
 							ingredient2: nodeIng2,
 						}},
-					}
-
-					select {
-					case resultChan <- rootNode:
-					case <-currentContext.Done():
-						return
-					}
+					})
 				}
 			}
-		}(topRecipePair, ctx)
+		})
 	}
+	group.wait()
 
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	for tree := range resultChan {
-		mu.Lock()
-		if maxPathsToReturn <= 0 || pathsFound < maxPathsToReturn {
-			collectedTrees = append(collectedTrees, tree)
-			pathsFound++
-			if maxPathsToReturn > 0 && pathsFound >= maxPathsToReturn {
-				cancel() 
+	// the same tree can come from a pair listed twice, or from a pair of one
+	// element twice with its two subtrees swapped
+	var collectedTrees []*Node
+	seenStructures := make(map[string]bool)
+	for _, trees := range treesPerRecipe {
+		for _, tree := range trees {
+			serialized := serializeTree(tree)
+			if (maxPathsToReturn <= 0 || len(collectedTrees) < maxPathsToReturn) && !seenStructures[serialized] {
+				seenStructures[serialized] = true
+				collectedTrees = append(collectedTrees, tree)
 			}
 		}
-		mu.Unlock()
 	}
 	
 	return collectedTrees
 }
 
-func expandElementParallel(
-	elementName string,
-	currentRecipeMap map[string][][]string,
-) []*Node {
-	var memo sync.Map
-	var wg sync.WaitGroup
-	jobChan := make(chan string, 100)
-
-	worker := func() {
-		for elem := range jobChan {
-			// fmt.Println(elem)
-			if _, exists := memo.Load(elem); exists {
-				wg.Done()
-				continue
-			}
-
-			recipes, ok := currentRecipeMap[elem]
-			if !ok || len(recipes) == 0 {
-				memo.Store(elem, []*Node{{element: elem}})
-				wg.Done()
-				continue
-			}
-
-			var nodes []*Node
-			for _, recipe := range recipes {
-				// fmt.Println("here")
-				if len(recipe) != 2 {
-					continue
-				}
-				ing1 := recipe[0]
-				ing2 := recipe[1]
-
-				// Add dependencies
-				if _, ok := memo.Load(ing1); !ok {
-					wg.Add(1)
-					jobChan <- ing1
-				}
-				if _, ok := memo.Load(ing2); !ok {
-					wg.Add(1)
-					jobChan <- ing2
-				}
-
-				var ing1Nodes, ing2Nodes []*Node
-				for {
-					if val, ok := memo.Load(ing1); ok {
-						ing1Nodes = val.([]*Node)
-						break
-					}
-					time.Sleep(time.Millisecond)
-				}
-				for {
-					if val, ok := memo.Load(ing2); ok {
-						ing2Nodes = val.([]*Node)
-						break
-					}
-					time.Sleep(time.Millisecond)
-				}
-
-				for _, n1 := range ing1Nodes {
-					for _, n2 := range ing2Nodes {
-						nodes = append(nodes, &Node{
-							element: elem,
-							combinations: []Recipe{{
-								ingredient1: n1,
-								ingredient2: n2,
-							}},
-						})
-					}
-				}
-			}
-			memo.Store(elem, nodes)
-			wg.Done()
-		}
-	}
-
-	// start workers
-	for i := 0; i < 8; i++ {
-		go worker()
-	}
-
-	wg.Add(1)
-	jobChan <- elementName
-
-	wg.Wait()
-	close(jobChan)
-
-	if val, ok := memo.Load(elementName); ok {
-		return val.([]*Node)
-	}
-	return []*Node{}
-}
-
+// expandElement lists the trees of an element, every recipe combined with every
+// pair of ingredient trees. An element already on the path is a cycle and has
+// no trees, and neither has an element that is not a leaf and has no recipes.
+// With limit > 0 only the first limit trees are kept: bfsAll pairs ingredient
+// trees row by row and stops after limit trees, so it never reaches the rest.
 func expandElement(
 	elementName string,
 	currentRecipeMap map[string][][]string,
 	pathVisited map[string]bool,
 	memo map[string][]*Node,
+	limit int,
+	opts SearchOptions,
 ) []*Node {
 	if nodes, found := memo[elementName]; found {
 		return nodes
 	}
 
-	if pathVisited[elementName] {
-		return []*Node{{element: elementName}}
+	if pathVisited[elementName] || opts.cancelled() {
+		return nil
 	}
 	pathVisited[elementName] = true
 	defer delete(pathVisited, elementName)
+	opts.events.emit(SearchEvent{Type: eventVisit, Element: elementName, Depth: len(pathVisited) - 1})
 
 	recipesForElement, exists := currentRecipeMap[elementName]
 	if !exists || len(recipesForElement) == 0 {
+		if !opts.isLeaf(elementName) {
+			return nil
+		}
 		node := &Node{element: elementName}
 		memo[elementName] = []*Node{node}
 		return []*Node{node}
@@ -319,25 +254,25 @@ func expandElement(
 
 	var allPossibleNodesForThisElement []*Node
 
-	for _, recipePair := range recipesForElement {
-		if len(recipePair) != 2 {
+	for i, recipePair := range recipesForElement {
+		if len(recipePair) != 2 || findRecipe(recipesForElement[:i], recipePair[0], recipePair[1]) != nil {
 			continue
 		}
 		ing1Name := recipePair[0]
 		ing2Name := recipePair[1]
 
-		expandedIngredient1Nodes := expandElement(ing1Name, currentRecipeMap, pathVisited, memo)
-		expandedIngredient2Nodes := expandElement(ing2Name, currentRecipeMap, pathVisited, memo)
-		
-		if len(expandedIngredient1Nodes) == 0 {
-			expandedIngredient1Nodes = []*Node{{element: ing1Name}}
-		}
-		if len(expandedIngredient2Nodes) == 0 {
-			expandedIngredient2Nodes = []*Node{{element: ing2Name}}
-		}
+		expandedIngredient1Nodes := expandElement(ing1Name, currentRecipeMap, pathVisited, memo, limit, opts)
+		expandedIngredient2Nodes := expandElement(ing2Name, currentRecipeMap, pathVisited, memo, limit, opts)
 
 		for _, nodeIng1 := range expandedIngredient1Nodes {
 			for _, nodeIng2 := range expandedIngredient2Nodes {
+				if limit > 0 && len(allPossibleNodesForThisElement) >= limit {
+					break
+				}
+				// a memoized ingredient tree can still lead back here
+				if containsElement(nodeIng1, elementName, nil) || containsElement(nodeIng2, elementName, nil) {
+					continue
+				}
 				currentNode := &Node{
 					element: elementName,
 					combinations: []Recipe{{
@@ -354,196 +289,26 @@ func expandElement(
 	return allPossibleNodesForThisElement
 }
 
-
-
-/*** FOR BIDIRECTIONAL ***/
-func multipleBfsForBidir(tree *Treebidir, numPaths int) []*Nodebidir {
-	fmt.Println("start multiple bfs")
-
-	if tree == nil || tree.root == nil {
-		return nil
-	}
-
-	if tree.root.isCycleNode {
-		return nil
-	}
-
-	baseLeaves := findBaseLeaves(tree.root, []*Nodebidir{})
-	if len(baseLeaves) == 0 {
-		return nil
-	}
-
-	var validLeaves []*Nodebidir
-	for _, leaf := range baseLeaves {
-		if !leaf.isCycleNode {
-			validLeaves = append(validLeaves, leaf)
-		}
+// containsElement reports whether element occurs anywhere in the tree. Trees
+// from expandElement share subtrees, so each node is walked once.
+func containsElement(node *Node, element string, seen map[*Node]bool) bool {
+	if node == nil {
+		return false
 	}
-
-	if len(validLeaves) == 0 {
-		return nil
-	}
-
-	resultChan := make(chan PathResult, numPaths*2)
-	var wg sync.WaitGroup
-	var resultsMutex sync.Mutex
-	var foundPaths []*Nodebidir
-	var numFoundPaths int
-
-	pathSignatures := make(map[string]bool)
-
-	for i, targetLeaf := range validLeaves {
-		wg.Add(1)
-		go func(leafIndex int, targetLeaf *Nodebidir) {
-			defer wg.Done()
-			resultsMutex.Lock()
-			shouldContinue := numFoundPaths < numPaths
-			resultsMutex.Unlock()
-
-			if !shouldContinue {
-				return
-			}
-
-			if targetLeaf.isCycleNode {
-				return
-			}
-
-			path, score := bfsToTarget(tree.root, targetLeaf)
-
-			if path != nil && !isPathCyclic(path) {
-				pathSignature := generatePathSignature(path)
-
-				resultChan <- PathResult{
-					path:          path,
-					score:         score,
-					pathSignature: pathSignature,
-				}
-			} else if path != nil {
-				// fmt.Printf("cyclic")
-			}
-		}(i, targetLeaf)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	for result := range resultChan {
-		resultsMutex.Lock()
-
-		if isPathCyclic(result.path) {
-			fmt.Printf("cyclic")
-			resultsMutex.Unlock()
-			continue
-		}
-
-		if numFoundPaths < numPaths && !pathSignatures[result.pathSignature] {
-			foundPaths = append(foundPaths, result.path)
-			numFoundPaths++
-			pathSignatures[result.pathSignature] = true
-		}
-		resultsMutex.Unlock()
-		if numFoundPaths >= numPaths {
-			break
-		}
+	if seen == nil {
+		seen = make(map[*Node]bool)
 	}
-	return foundPaths
-}
-
-func bfsToTarget(root *Nodebidir, targetLeaf *Nodebidir) (*Nodebidir, int) {
-	if root == nil || targetLeaf == nil {
-		return nil, -1
+	if seen[node] {
+		return false
 	}
-
-	if root.isCycleNode || targetLeaf.isCycleNode {
-		return nil, -1
+	seen[node] = true
+	if node.element == element {
+		return true
 	}
-
-	queue := list.New()
-	visited := make(map[*Nodebidir]*Nodebidir)
-	queue.PushBack(root)
-	visited[root] = nil
-	depth := make(map[*Nodebidir]int)
-	depth[root] = 0
-
-	foundTarget := false
-
-	for queue.Len() > 0 && !foundTarget {
-		current := queue.Front().Value.(*Nodebidir)
-		queue.Remove(queue.Front())
-
-		if current.isCycleNode {
-			continue
-		}
-
-		if current == targetLeaf {
-			foundTarget = true
-			break
-		}
-
-		for _, recipe := range current.combinations {
-			children := []*Nodebidir{recipe.ingredient1, recipe.ingredient2}
-			for _, child := range children {
-				if child == nil || child.isCycleNode {
-					continue
-				}
-
-				if _, found := visited[child]; !found {
-					queue.PushBack(child)
-					visited[child] = current
-					depth[child] = depth[current] + 1
-
-					if child == targetLeaf {
-						foundTarget = true
-						break
-					}
-				}
-			}
-			if foundTarget {
-				break
-			}
+	for _, recipe := range node.combinations {
+		if containsElement(recipe.ingredient1, element, seen) || containsElement(recipe.ingredient2, element, seen) {
+			return true
 		}
 	}
-
-	if !foundTarget {
-		return nil, -1
-	}
-	resultTree := constructPathTree(targetLeaf, visited)
-
-	return resultTree, depth[targetLeaf]
+	return false
 }
-
-func constructPathTree(targetNode *Nodebidir, visited map[*Nodebidir]*Nodebidir) *Nodebidir {
-	path := []*Nodebidir{}
-	curr := targetNode
-	for curr != nil {
-		path = append([]*Nodebidir{curr}, path...)
-		curr = visited[curr]
-	}
-	return buildShortestPathTree(path, recipeData.Recipes[targetNode.element])
-}
-
-
-// func main() {
-
-// 	loadRecipes("recipes.json")
-// 	target := "Rain"
-// 	numOfRecipe := 2
-
-// 	// ini buat debug result aja
-// 	// tree := InitTree(target, recipeData.Recipes[target])
-// 	// printTree(tree)
-
-// 	// Try Single Recipe
-// 	// result, nodes := searchBFSOne(target)
-// 	// printTree(result)
-// 	// fmt.Printf("Number of visited nodes: %d\n", nodes)
-
-// 	// Try multiple Recipe
-// 	result2, nodes2 := searchBFSMultiple(target, numOfRecipe)
-// 	for _, recipe := range result2 {
-// 		printTree(recipe)
-// 	}
-// 	fmt.Printf("Number of visited nodes: %d\n", nodes2)
-// }
\ No newline at end of file
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*** SEARCH RESULT CACHE ***/
+
+const (
+	defaultCacheSize = 256
+	defaultCacheTTL  = 10 * time.Minute
+)
+
+// resultCache is a least recently used cache of search results, keyed by the
+// request fields that change what a search finds. Entries older than ttl are
+// treated as missing; size <= 0 disables the cache.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	result searchResult
+	stored time.Time
+}
+
+var searchCache = newResultCache(defaultCacheSize, defaultCacheTTL)
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// newResultCacheFromEnv reads SEARCH_CACHE_SIZE (entries) and SEARCH_CACHE_TTL
+// (a duration such as "5m"), falling back to the defaults.
+func newResultCacheFromEnv() *resultCache {
+	size := defaultCacheSize
+	if raw := os.Getenv("SEARCH_CACHE_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("invalid SEARCH_CACHE_SIZE %q: %v", raw, err)
+		}
+		size = n
+	}
+	ttl := defaultCacheTTL
+	if raw := os.Getenv("SEARCH_CACHE_TTL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid SEARCH_CACHE_TTL %q: %v", raw, err)
+		}
+		ttl = d
+	}
+	return newResultCache(size, ttl)
+}
+
+func (c *resultCache) get(key string) (searchResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return searchResult{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Since(entry.stored) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return searchResult{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.result, true
+}
+
+func (c *resultCache) put(key string, result searchResult) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cacheEntry{key: key, result: result, stored: time.Now()}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, result: result, stored: time.Now()})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// clear drops every entry. loadRecipes calls it, since results found on the
+// previous dataset may no longer be right.
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// searchCacheKey identifies the search a request runs. Element lists are
+// sorted since their order doesn't change the result, and output shaping
+// (DAGs, plans, the response version) is left out because it is applied
+// after the cache.
+func searchCacheKey(req SearchRequest) string {
+	req.Output = ""
+	req.Plan = false
+	req.ResponseVersion = 0
+	req.Inventory = sortedCopy(req.Inventory)
+	req.Exclude = sortedCopy(req.Exclude)
+	req.Require = sortedCopy(req.Require)
+	key, _ := json.Marshal(req)
+	return string(key)
+}
+
+func sortedCopy(elements []string) []string {
+	if len(elements) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), elements...)
+	sort.Strings(sorted)
+	return sorted
+}
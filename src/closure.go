@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+)
+
+/*** REACHABILITY CLOSURE ***/
+
+// BlockedElement explains why an element cannot be made from a start set.
+// Recipe is the recipe with the fewest ingredients missing, Missing those
+// ingredients, and Cause the prerequisite found by following the first missing
+// ingredient down until an element with no usable recipe, or one that only
+// leads back to itself.
+type BlockedElement struct {
+	Element string   `json:"element"`
+	Recipe  []string `json:"recipe,omitempty"`
+	Missing []string `json:"missing"`
+	Cause   string   `json:"cause"`
+}
+
+type Closure struct {
+	Reachable   []string         `json:"reachable"`
+	Unreachable []BlockedElement `json:"unreachable"`
+}
+
+// computeClosure forward-chains from start: a recipe fires once both of its
+// ingredients are reachable, until no recipe can fire. Every element of
+// elements that is not reachable is reported with what blocks it.
+func computeClosure(start []string, elements []string, recipes map[string][][]string) Closure {
+	type use struct {
+		product string
+		waiting int
+	}
+	uses := make(map[string][]*use)
+	for element, combs := range recipes {
+		for _, pair := range combs {
+			u := &use{product: element}
+			for _, ingredient := range uniqueIngredients(pair) {
+				uses[ingredient] = append(uses[ingredient], u)
+				u.waiting++
+			}
+		}
+	}
+
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, element := range start {
+		if !reachable[element] {
+			reachable[element] = true
+			queue = append(queue, element)
+		}
+	}
+	for len(queue) > 0 {
+		element := queue[0]
+		queue = queue[1:]
+		for _, u := range uses[element] {
+			u.waiting--
+			if u.waiting == 0 && !reachable[u.product] {
+				reachable[u.product] = true
+				queue = append(queue, u.product)
+			}
+		}
+	}
+
+	closure := Closure{Reachable: sortedKeys(reachable), Unreachable: []BlockedElement{}}
+	causes := make(map[string]string)
+	for _, element := range elements {
+		if reachable[element] {
+			continue
+		}
+		blocked := BlockedElement{Element: element}
+		blocked.Recipe, blocked.Missing = closestRecipe(element, recipes, reachable)
+		blocked.Cause = blockingCause(element, recipes, reachable, causes, map[string]bool{})
+		closure.Unreachable = append(closure.Unreachable, blocked)
+	}
+	sort.Slice(closure.Unreachable, func(i, j int) bool {
+		return closure.Unreachable[i].Element < closure.Unreachable[j].Element
+	})
+	return closure
+}
+
+// uniqueIngredients returns the ingredients of pair once each, so Fire + Fire
+// waits for Fire only once.
+func uniqueIngredients(pair []string) []string {
+	var unique []string
+	for i, ingredient := range pair {
+		if i == 0 || ingredient != pair[0] {
+			unique = append(unique, ingredient)
+		}
+	}
+	return unique
+}
+
+// closestRecipe picks the recipe of element with the fewest unreachable
+// ingredients, and returns it with those ingredients.
+func closestRecipe(element string, recipes map[string][][]string, reachable map[string]bool) ([]string, []string) {
+	var best []string
+	bestMissing := []string{}
+	for _, pair := range recipes[element] {
+		missing := []string{}
+		for _, ingredient := range uniqueIngredients(pair) {
+			if !reachable[ingredient] {
+				missing = append(missing, ingredient)
+			}
+		}
+		if best == nil || len(missing) < len(bestMissing) {
+			best, bestMissing = pair, missing
+		}
+	}
+	return best, bestMissing
+}
+
+func blockingCause(element string, recipes map[string][][]string, reachable map[string]bool,
+	causes map[string]string, onPath map[string]bool) string {
+	if cause, ok := causes[element]; ok {
+		return cause
+	}
+	_, missing := closestRecipe(element, recipes, reachable)
+	if len(missing) == 0 || onPath[missing[0]] {
+		causes[element] = element
+		return element
+	}
+	onPath[element] = true
+	cause := blockingCause(missing[0], recipes, reachable, causes, onPath)
+	delete(onPath, element)
+	causes[element] = cause
+	return cause
+}
+
+// reportDroppedElements logs how many scraped elements ScrapeRecipes left out,
+// and in debug mode which ones and why. Its availableElements filter only
+// accepts ingredients from earlier tables, so an element the closure can still
+// reach was dropped by table order, while an unreachable one is blocked by a
+// missing or excluded prerequisite.
+func reportDroppedElements(scraped map[string][][]string, kept OutputData) {
+	var elements []string
+	for element := range scraped {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+
+	closure := computeClosure([]string{"Air", "Earth", "Fire", "Water"}, elements, scraped)
+	byOrder := 0
+	for _, element := range closure.Reachable {
+		if _, ok := kept.Recipes[element]; !ok && scraped[element] != nil {
+			byOrder++
+			if debugMode {
+				log.Printf("Dropped %s: reachable, but its ingredients come from later tables\n", element)
+			}
+		}
+	}
+	if debugMode {
+		for _, blocked := range closure.Unreachable {
+			log.Printf("Dropped %s: blocked by %s\n", blocked.Element, blocked.Cause)
+		}
+	}
+	if dropped := byOrder + len(closure.Unreachable); dropped > 0 {
+		log.Printf("Dropped %d scraped elements: %d by table order, %d blocked (SEARCH_DEBUG lists them)\n",
+			dropped, byOrder, len(closure.Unreachable))
+	}
+}
+
+type ClosureResponse struct {
+	Start []string `json:"start"`
+	Closure
+}
+
+// closureHandler serves GET /api/closure?start=Water,Fire,Stone. Unlike
+// /api/reachable the base elements are not added: start is the whole set the
+// closure grows from, and defaults to the four base elements.
+func closureHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := resolveNames(splitElementList(r.URL.Query().Get("start")))
+	if len(start) == 0 {
+		start = []string{"Air", "Earth", "Fire", "Water"}
+	}
+	for _, element := range start {
+		if lookupElement(element) == nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "unknown element " + element})
+			return
+		}
+	}
+
+	log.Printf("Computing closure of %v\n", start)
+	closure := computeClosure(start, recipeData.Elements, searchIndex.recipes)
+	writeJSON(w, http.StatusOK, ClosureResponse{Start: start, Closure: closure})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*** MULTI-TARGET COMBINED PLAN ***/
+
+// combinedPasses bounds how often every target is re-solved against the others.
+const combinedPasses = 5
+
+// searchCombined picks one recipe per element so every target can be made,
+// trying to keep the number of distinct combinations small. Each target is
+// first solved on its own with unit step costs. Then each target is solved
+// again with the recipes the other targets already use made free, and the new
+// tree is kept when the merged plan gets smaller, until a pass changes nothing.
+// It returns the merged recipe choices and the combinations needed when every
+// target is solved on its own.
+func searchCombined(targets []string, opts SearchOptions) (map[string][]string, int) {
+	solve := func(target string, shared map[string][]string) map[string][]string {
+		cost := func(element string, pair []string) float64 {
+			if pair == nil {
+				return 0
+			}
+			if p, ok := shared[element]; ok && findRecipe([][]string{p}, pair[0], pair[1]) != nil {
+				return 0
+			}
+			return 1
+		}
+		choice := make(map[string][]string)
+		if tree, _, _ := searchCostOne(target, opts, cost); tree != nil {
+			collectChoices(tree.root, choice)
+		}
+		return choice
+	}
+
+	solutions := make([]map[string][]string, len(targets))
+	separate := 0
+	for i, target := range targets {
+		solutions[i] = solve(target, nil)
+		separate += len(solutions[i])
+	}
+
+	best := mergeChoices(targets, solutions)
+	for pass := 0; pass < combinedPasses; pass++ {
+		improved := false
+		for i, target := range targets {
+			others := append(append([]map[string][]string{}, solutions[:i]...), solutions[i+1:]...)
+			candidate := solve(target, mergeChoices(nil, others))
+
+			trial := append([]map[string][]string{}, solutions...)
+			trial[i] = candidate
+			if merged := mergeChoices(targets, trial); len(merged) < len(best) {
+				solutions[i], best, improved = candidate, merged, true
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+	return best, separate
+}
+
+// collectChoices records the recipe every crafted element of a tree uses.
+func collectChoices(node *Node, choice map[string][]string) {
+	if node == nil || len(node.combinations) == 0 {
+		return
+	}
+	recipe := node.combinations[0]
+	choice[node.element] = []string{recipe.ingredient1.element, recipe.ingredient2.element}
+	collectChoices(recipe.ingredient1, choice)
+	collectChoices(recipe.ingredient2, choice)
+}
+
+// mergeChoices combines per-target choices, the earlier solution winning when
+// two pick different recipes for one element. With targets given, only the
+// choices needed to make them are kept. Following mixed choices can't loop: an
+// element's recipe comes from the earliest solution choosing it, which also
+// chooses the recipe's ingredients, so each step stays within one solution's
+// tree or moves to an earlier solution.
+func mergeChoices(targets []string, solutions []map[string][]string) map[string][]string {
+	merged := make(map[string][]string)
+	for _, solution := range solutions {
+		for element, pair := range solution {
+			if _, ok := merged[element]; !ok {
+				merged[element] = pair
+			}
+		}
+	}
+	if targets == nil {
+		return merged
+	}
+
+	needed := make(map[string][]string)
+	var walk func(element string)
+	walk = func(element string) {
+		pair, ok := merged[element]
+		if _, done := needed[element]; done || !ok {
+			return
+		}
+		needed[element] = pair
+		walk(pair[0])
+		walk(pair[1])
+	}
+	for _, target := range targets {
+		walk(target)
+	}
+	return needed
+}
+
+type CombinedRequest struct {
+	Targets   []string `json:"targets"`
+	Inventory []string `json:"inventory"`
+	Exclude   []string `json:"exclude"`
+	Output    string   `json:"output"` // "plan" (default) or "dag"
+}
+
+type CombinedResponse struct {
+	Targets              []string     `json:"targets"`
+	Combinations         int          `json:"combinations"`         // distinct combinations in the merged plan
+	SeparateCombinations int          `json:"separateCombinations"` // sum over targets solved one by one
+	Plan                 []PlanStep   `json:"plan,omitempty"`
+	DAG                  *CombinedDAG `json:"dag,omitempty"`
+	ExecutionTime        float64      `json:"executionTime"`
+}
+
+// combinedHandler serves POST /api/combined: one plan making every target.
+func combinedHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "POST, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CombinedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid input"}`, http.StatusBadRequest)
+		log.Printf("Failed to decode combined request: %v\n", err)
+		return
+	}
+	req.Output = specEnum("CombinedRequest", "output", req.Output)
+	if len(req.Targets) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "targets must not be empty"})
+		return
+	}
+	if req.Output != "" && req.Output != "plan" && req.Output != "dag" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "output must be plan or dag"})
+		return
+	}
+
+	opts := newSearchOptions(SearchRequest{Inventory: req.Inventory, Exclude: req.Exclude})
+	for i, target := range req.Targets {
+		req.Targets[i] = resolveName(target)
+		if err := diagnoseTarget(req.Targets[i], opts); err != nil {
+			writeSearchError(w, err)
+			return
+		}
+	}
+
+	log.Printf("Combining plans for %v\n", req.Targets)
+	startTime := time.Now()
+	choice, separate := searchCombined(req.Targets, opts)
+
+	var roots []*TreeNode
+	for _, target := range req.Targets {
+		root := convertToTreeNode(buildCostTree(target, choice))
+		if len(root.Children) == 0 && !opts.isLeaf(target) {
+			writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: fmt.Sprintf("no recipe for %s", target)})
+			return
+		}
+		roots = append(roots, root)
+	}
+
+	resp := CombinedResponse{
+		Targets:              req.Targets,
+		Combinations:         len(choice),
+		SeparateCombinations: separate,
+	}
+	if req.Output == "dag" {
+		resp.DAG = buildCombinedDAG(roots)
+	} else {
+		resp.Plan = buildCombinedPlan(roots)
+	}
+	resp.ExecutionTime = float64(time.Since(startTime).Milliseconds())
+	writeJSON(w, http.StatusOK, resp)
+}
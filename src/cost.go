@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/heap"
+)
+
+/*** MINIMUM COST RECIPE ***/
+
+// CostWeights is the cost model a request can supply. A combination step costs
+// its pair weight ("Fire+Water", either order) if given, else the weight of the
+// element it makes, else the default: the element's tier, or 1 per step when
+// Default is "unit". Element weights on leaves penalise using that leaf.
+type CostWeights struct {
+	Elements map[string]float64 `json:"elements"`
+	Pairs    map[string]float64 `json:"pairs"`
+	Default  string             `json:"default"` // "tier" (default) or "unit"
+}
+
+// costModeError rejects asking the Cost search for more than one tree: it only
+// finds the cheapest. Without a searchMode it searches like single.
+func costModeError(req SearchRequest) error {
+	if req.Algorithm != "Cost" || req.SearchMode == "" || req.SearchMode == "single" {
+		return nil
+	}
+	field := FieldError{Field: "searchMode", Message: "must be single with algorithm Cost"}
+	return &SearchError{Code: errInvalidRequest, Message: "invalid request: searchMode " + field.Message, Fields: []FieldError{field}}
+}
+
+// costFunc prices one node of a tree. pair is nil for leaves.
+type costFunc func(element string, pair []string) float64
+
+// computeTiers gives the tier of every element that can be made: base elements
+// are tier 0 and an element is one tier above the ingredients of its easiest
+// recipe.
+func computeTiers(data OutputData) map[string]int {
+	tiers := map[string]int{"Air": 0, "Earth": 0, "Fire": 0, "Water": 0}
+	changed := true
+	for changed {
+		changed = false
+		for element, recipeMap := range data.Recipes {
+			for _, pair := range recipeMap[element] {
+				if len(pair) != 2 {
+					continue
+				}
+				t1, ok1 := tiers[pair[0]]
+				t2, ok2 := tiers[pair[1]]
+				if !ok1 || !ok2 {
+					continue
+				}
+				tier := max(t1, t2) + 1
+				if cur, ok := tiers[element]; !ok || tier < cur {
+					tiers[element] = tier
+					changed = true
+				}
+			}
+		}
+	}
+	return tiers
+}
+
+func (c *CostWeights) costFunc() costFunc {
+	var weights CostWeights
+	if c != nil {
+		weights = *c
+	}
+	return func(element string, pair []string) float64 {
+		if pair == nil {
+			return weights.Elements[element]
+		}
+		if w, ok := weights.Pairs[pair[0]+"+"+pair[1]]; ok {
+			return w
+		}
+		if w, ok := weights.Pairs[pair[1]+"+"+pair[0]]; ok {
+			return w
+		}
+		if w, ok := weights.Elements[element]; ok {
+			return w
+		}
+		if weights.Default == "unit" {
+			return 1
+		}
+		return float64(tierOf(element))
+	}
+}
+
+type costItem struct {
+	element string
+	cost    float64
+}
+
+type costQueue []costItem
+
+func (q costQueue) Len() int            { return len(q) }
+func (q costQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q costQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *costQueue) Push(x interface{}) { *q = append(*q, x.(costItem)) }
+func (q *costQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// searchCostOne finds the recipe tree of target with the lowest total cost. It
+// settles elements cheapest first, like Dijkstra, and a recipe becomes usable
+// once both of its ingredients are settled, so the chosen recipes never loop.
+func searchCostOne(target string, opts SearchOptions, cost costFunc) (*Tree, int, float64) {
+	recipes := opts.recipesFor(target)
+
+	type use struct {
+		product string
+		pair    []string
+		waiting int
+	}
+	uses := make(map[string][]*use)
+	queue := &costQueue{}
+	best := make(map[string]float64)
+	choice := make(map[string][]string)
+
+	for element, combs := range recipes {
+		for _, pair := range combs {
+			if len(pair) != 2 {
+				continue
+			}
+			u := &use{product: element, pair: pair, waiting: 2}
+			if pair[0] == pair[1] {
+				u.waiting = 1
+			}
+			uses[pair[0]] = append(uses[pair[0]], u)
+			if pair[0] != pair[1] {
+				uses[pair[1]] = append(uses[pair[1]], u)
+			}
+			for _, ingredient := range pair {
+				if _, seen := best[ingredient]; !seen && opts.isLeaf(ingredient) {
+					best[ingredient] = cost(ingredient, nil)
+					heap.Push(queue, costItem{ingredient, best[ingredient]})
+					opts.events.emit(SearchEvent{Type: eventPush, Element: ingredient})
+				}
+			}
+		}
+	}
+	if opts.isLeaf(target) {
+		best[target] = cost(target, nil)
+		heap.Push(queue, costItem{target, best[target]})
+		opts.events.emit(SearchEvent{Type: eventPush, Element: target})
+	}
+
+	settled := make(map[string]bool)
+	for queue.Len() > 0 && !opts.cancelled() {
+		item := heap.Pop(queue).(costItem)
+		if settled[item.element] || item.cost > best[item.element] {
+			continue
+		}
+		settled[item.element] = true
+		opts.events.emit(SearchEvent{Type: eventPop, Element: item.element})
+		if item.element == target {
+			break
+		}
+
+		for _, u := range uses[item.element] {
+			u.waiting--
+			if u.waiting > 0 || settled[u.product] {
+				continue
+			}
+			total := cost(u.product, u.pair) + best[u.pair[0]] + best[u.pair[1]]
+			if cur, ok := best[u.product]; !ok || total < cur {
+				best[u.product] = total
+				choice[u.product] = u.pair
+				heap.Push(queue, costItem{u.product, total})
+				opts.events.emit(SearchEvent{Type: eventPush, Element: u.product, Parent: item.element})
+			}
+		}
+	}
+
+	if !settled[target] {
+		return nil, len(settled), 0
+	}
+	return &Tree{root: buildCostTree(target, choice)}, len(settled), best[target]
+}
+
+func buildCostTree(element string, choice map[string][]string) *Node {
+	node := &Node{element: element}
+	if pair, ok := choice[element]; ok {
+		node.combinations = []Recipe{{
+			ingredient1: buildCostTree(pair[0], choice),
+			ingredient2: buildCostTree(pair[1], choice),
+		}}
+	}
+	return node
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*** SHARED-SUBTREE (DAG) OUTPUT ***/
+
+// RecipeDAG is the DAG form of one recipe tree, returned in "dags" when a
+// request sets "output": "dag". Identical subtrees are stored once, so an
+// intermediate such as Stone used in several branches is a single node.
+//
+//	{
+//	  "root": 4,
+//	  "nodes": [
+//	    {"id": 0, "name": "Water", "children": []},
+//	    {"id": 1, "name": "Earth", "children": []},
+//	    {"id": 2, "name": "Mud", "children": [0, 1]},
+//	    {"id": 3, "name": "Fire", "children": []},
+//	    {"id": 4, "name": "Brick", "children": [2, 3]}
+//	  ]
+//	}
+//
+// Node ids are indexes into nodes. Every child id refers to a node listed
+// earlier, so ingredients always come before what they make and the root is
+// last. Leaves have an empty children list.
+type RecipeDAG struct {
+	Root  int       `json:"root"`
+	Nodes []DAGNode `json:"nodes"`
+}
+
+type DAGNode struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Children []int  `json:"children"`
+}
+
+// CombinedDAG is the DAG of several trees built together, so a subtree they
+// share is one node. Roots holds the id of each tree's root, in request order.
+type CombinedDAG struct {
+	Roots []int     `json:"roots"`
+	Nodes []DAGNode `json:"nodes"`
+}
+
+func buildCombinedDAG(roots []*TreeNode) *CombinedDAG {
+	dag := &RecipeDAG{Nodes: []DAGNode{}}
+	ids := make(map[string]int)
+	combined := &CombinedDAG{Roots: []int{}}
+	for _, root := range roots {
+		combined.Roots = append(combined.Roots, addDAGNode(root, dag, ids))
+	}
+	combined.Nodes = dag.Nodes
+	return combined
+}
+
+func buildRecipeDAG(root *TreeNode) *RecipeDAG {
+	if root == nil {
+		return nil
+	}
+	dag := &RecipeDAG{Nodes: []DAGNode{}}
+	ids := make(map[string]int)
+	dag.Root = addDAGNode(root, dag, ids)
+	return dag
+}
+
+// addDAGNode adds node after its children and returns its id. Two subtrees
+// share an id when they have the same name and the same child ids, in any order.
+func addDAGNode(node *TreeNode, dag *RecipeDAG, ids map[string]int) int {
+	children := []int{}
+	for _, child := range node.Children {
+		if child != nil {
+			children = append(children, addDAGNode(child, dag, ids))
+		}
+	}
+
+	keyParts := make([]string, len(children))
+	sorted := append([]int(nil), children...)
+	sort.Ints(sorted)
+	for i, id := range sorted {
+		keyParts[i] = strconv.Itoa(id)
+	}
+	key := node.Name + "(" + strings.Join(keyParts, ",") + ")"
+	if id, ok := ids[key]; ok {
+		return id
+	}
+
+	id := len(dag.Nodes)
+	ids[key] = id
+	dag.Nodes = append(dag.Nodes, DAGNode{ID: id, Name: node.Name, Children: children})
+	return id
+}
+
+func buildRecipeDAGs(trees []*TreeNode) []*RecipeDAG {
+	dags := make([]*RecipeDAG, 0, len(trees))
+	for _, tree := range trees {
+		dags = append(dags, buildRecipeDAG(tree))
+	}
+	return dags
+}
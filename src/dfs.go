@@ -2,74 +2,82 @@ package main
 
 import (
 	"fmt"
-	"time"
-	"context"
-	"sync"
 )
 
 /*** SINGLE RECIPE DFS ***/
-var memoSD map[string]*Node
-var mainData map[string][][]string
-var visitedDFS map[string]bool
-var currentPath map[string]bool
+// dfsSearch is the state of one single recipe DFS
+type dfsSearch struct {
+	memo    *nodeMemo
+	recipes map[string][][]string
+	path    map[string]bool
+	stack   []string // path in order, for the parent of push events
+	visited map[string]bool
+}
 
-func searchDFSOne(target string) (*Tree, int) {
-	fmt.Println("start dfs single")
-	memoSD = make(map[string]*Node)
-	mainData = recipeData.Recipes[target]
-	visitedDFS = make(map[string]bool)
-	currentPath = make(map[string]bool)
-	visitedNodes := make(map[string]bool)
+func searchDFSOne(target string, opts SearchOptions) (*Tree, int) {
+	s := &dfsSearch{
+		memo:    opts.nodeMemo(),
+		recipes: opts.recipesFor(target),
+		path:    make(map[string]bool),
+		visited: make(map[string]bool),
+	}
 	
-	result, found := dfsOne(target, visitedNodes)
+	result, found := s.dfsOne(target, opts)
 
-	visitedNodeCount := len(visitedNodes)
-	fmt.Println("DFS done")
+	visitedNodeCount := len(s.visited)
 	if found {
 		return &Tree{root: result}, visitedNodeCount
 	}
 	return nil, 0
 }
 
-func dfsOne(element string, visitedNodes map[string]bool) (*Node, bool) {
-	visitedNodes[element] = true
-	
-	visitedDFS[element] = true
-	if _, inPath := currentPath[element]; inPath {
+func (s *dfsSearch) dfsOne(element string, opts SearchOptions) (*Node, bool) {
+	s.visited[element] = true
+	if _, inPath := s.path[element]; inPath || opts.cancelled() {
 		return nil, false
 	}
+	opts.events.emit(SearchEvent{Type: eventVisit, Element: element, Depth: len(s.stack)})
 
-	currentPath[element] = true
-	defer delete(currentPath, element)
+	parent := ""
+	if len(s.stack) > 0 {
+		parent = s.stack[len(s.stack)-1]
+	}
+	opts.events.emit(SearchEvent{Type: eventPush, Element: element, Parent: parent, Depth: len(s.stack)})
+	s.path[element] = true
+	s.stack = append(s.stack, element)
+	defer func() {
+		delete(s.path, element)
+		s.stack = s.stack[:len(s.stack)-1]
+		opts.events.emit(SearchEvent{Type: eventPop, Element: element, Depth: len(s.stack)})
+	}()
 
-	if isBase(element) {
+	if opts.isLeaf(element) {
 		return &Node{element: element}, true
 	}
 
-	if res, ok := memoSD[element]; ok {
+	if res, ok := s.memo.get(element); ok && !splicesAncestor(res, s.path) {
 		return res, true
 	}
 
-	res := &Node{element: element, combinations: []Recipe{}}
-	memoSD[element] = res
-
-	if recipes, ok := mainData[element]; ok {
-		for _, ingredients := range recipes {
-			left, leftValid := dfsOne(ingredients[0], visitedNodes)
+	if recipes, ok := s.recipes[element]; ok {
+		for _, ingredients := range shortestFirst(element, recipes) {
+			left, leftValid := s.dfsOne(ingredients[0], opts)
 			if !leftValid {
 				continue
 			}
 			
-			right, rightValid := dfsOne(ingredients[1], visitedNodes)
+			right, rightValid := s.dfsOne(ingredients[1], opts)
 			if !rightValid {
 				continue
 			}
 			
-			res.combinations = append(res.combinations, Recipe{
+			// only finished subtrees are memoized, so a search sharing the
+			// memo never sees one still being built
+			res := &Node{element: element, combinations: []Recipe{{
 				ingredient1: left,
 				ingredient2: right,
-			})
-			
+			}}}
+			s.memo.put(element, res)
 			return res, true
 		}
 	}
@@ -77,8 +85,6 @@ func dfsOne(element string, visitedNodes map[string]bool) (*Node, bool) {
 }
 
 /*** MULTIPLE RECIPE DFS ***/
-var mainDataMul map[string][][]string
-
 func serializeTree(node *Node) string {
 	if node == nil {
 		return ""
@@ -96,49 +102,41 @@ func serializeTree(node *Node) string {
 	return fmt.Sprintf("%s(%s,%s)", node.element, left, right)
 }
 
-func searchDFSMultiple(target string, numOfPath int) ([]*Tree, []int) {
-	fmt.Println("start multiple")
-	mainDataMul = recipeData.Recipes[target]
+func searchDFSMultiple(target string, numOfPath int, opts SearchOptions) ([]*Tree, []int) {
+	mainDataMul := opts.recipesFor(target)
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	
-	resultChan := make(chan *Node, numOfPath*2)
-	var wg sync.WaitGroup
-	
-	var seenStructuresMutex sync.Mutex
-	seenStructures := make(map[string]bool)
+	var group searchGroup
 	
 	targetCombs, exists := mainDataMul[target]
 	if !exists || len(targetCombs) == 0 {
 		return []*Tree{{root: &Node{element: target}}}, []int{1}
 	}
 	
-	for _, pair := range targetCombs {
+	// every goroutine fills its own slot, so the trees come in recipe order
+	// however the goroutines are scheduled
+	resultsPerPair := make([][]*Node, len(targetCombs))
+	for pairIndex, pair := range targetCombs {
 		if len(pair) != 2 {
 			continue
 		}
+		// a recipe that needs the target can't make it
 		if pair[0] == target || pair[1] == target {
-			fmt.Printf("loop detected: %s\n", target)
 			continue
 		}
 		
-		wg.Add(1)
-		go func(combo []string) {
-			defer wg.Done()
-			
+		group.spawn(func() {
 			currentPath := make(map[string]bool)
 			currentPath[target] = true
 			
 			leftPath := copyVisitedMap(currentPath)
-			leftResults := dfsSubTree(ctx, combo[0], mainDataMul, leftPath, 0)
+			leftResults := dfsSubTree(pair[0], mainDataMul, leftPath, 0, opts)
 			
 			if len(leftResults) == 0 {
 				return
 			}
 			
 			rightPath := copyVisitedMap(currentPath)
-			rightResults := dfsSubTree(ctx, combo[1], mainDataMul, rightPath, 0)
+			rightResults := dfsSubTree(pair[1], mainDataMul, rightPath, 0, opts)
 			
 			if len(rightResults) == 0 {
 				return
@@ -147,50 +145,32 @@ func searchDFSMultiple(target string, numOfPath int) ([]*Tree, []int) {
 			maxCombos := 3
 			for i := 0; i < min(len(leftResults), maxCombos); i++ {
 				for j := 0; j < min(len(rightResults), maxCombos); j++ {
-					select {
-					case <-ctx.Done():
+					if opts.cancelled() {
 						return
-					default:
-						finalTreeRoot := &Node{
-							element: target,
-							combinations: []Recipe{{
-								ingredient1: leftResults[i],
-								ingredient2: rightResults[j],
-							}},
-						}
-						
-						serialized := serializeTree(finalTreeRoot)
-						seenStructuresMutex.Lock()
-						seen := seenStructures[serialized]
-						if !seen {
-							seenStructures[serialized] = true
-							seenStructuresMutex.Unlock()
-							
-							select {
-							case resultChan <- finalTreeRoot:
-								// success
-							default:
-								// channel buffer full, continue
-							}
-						} else {
-							seenStructuresMutex.Unlock()
-						}
 					}
+					resultsPerPair[pairIndex] = append(resultsPerPair[pairIndex], &Node{
+						element: target,
+						combinations: []Recipe{{
+							ingredient1: leftResults[i],
+							ingredient2: rightResults[j],
+						}},
+					})
 				}
 			}
-		}(pair)
+		})
 	}
+	group.wait()
 	
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-	
+	// the same tree can come from a pair listed twice
 	var allResults []*Node
-	for result := range resultChan {
-		allResults = append(allResults, result)
-		if len(allResults) >= numOfPath {
-			break
+	seenStructures := make(map[string]bool)
+	for _, results := range resultsPerPair {
+		for _, result := range results {
+			serialized := serializeTree(result)
+			if len(allResults) < numOfPath && !seenStructures[serialized] {
+				seenStructures[serialized] = true
+				allResults = append(allResults, result)
+			}
 		}
 	}
 	
@@ -202,66 +182,51 @@ func searchDFSMultiple(target string, numOfPath int) ([]*Tree, []int) {
 		pathElementCounts = append(pathElementCounts, getPathElementCount(rootNode))
 	}
 	
-	fmt.Printf("found %d unique recipe paths\n", len(trees))
 	return trees, pathElementCounts
 }
 
-func dfsSubTree(ctx context.Context, element string, currentRecipeMap map[string][][]string, currentPath map[string]bool, depth int) []*Node {
-	// fmt.Println(element)
-	select {
-	case <-ctx.Done():
-		return []*Node{} 
-	default:
+func dfsSubTree(element string, currentRecipeMap map[string][][]string, currentPath map[string]bool, depth int, opts SearchOptions) []*Node {
+	if currentPath[element] || opts.cancelled() {
+		return []*Node{}
 	}
+	opts.events.emit(SearchEvent{Type: eventVisit, Element: element, Depth: depth})
 
-	maxDepth := 15
-	if depth > maxDepth {
+	if opts.isLeaf(element) {
 		return []*Node{{element: element}}
 	}
-	
-	if currentPath[element] {
-		return []*Node{}
-	}
-
+	// an element without recipes can't be made, and one past the depth limit
+	// isn't expanded: either way the branch fails instead of ending in a leaf
+	// that isn't one
+	maxDepth := 15
 	combs, exists := currentRecipeMap[element]
-	if !exists || len(combs) == 0 || isBase(element) {
-		return []*Node{{element: element}}
+	if !exists || len(combs) == 0 || depth > maxDepth {
+		return []*Node{}
 	}
 
 	currentPath[element] = true
 	
 	if depth < 3 && len(combs) > 1 {
-		var wg sync.WaitGroup
-		resultsMutex := sync.Mutex{}
-		var allPossibleNodesForElement []*Node
+		var group searchGroup
 		
 		maxCombsToExplore := min(len(combs), 2)
 		combsToExplore := combs[:maxCombsToExplore]
+		nodesPerPair := make([][]*Node, len(combsToExplore))
 		
-		for _, pair := range combsToExplore {
+		for pairIndex, pair := range combsToExplore {
 			if len(pair) != 2 {
 				continue
 			}
 			
-			wg.Add(1)
-			go func(ingredients []string) {
-				defer wg.Done()
-				
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-				
+			group.spawn(func() {
 				leftPath := copyVisitedMap(currentPath)
-				leftResults := dfsSubTree(ctx, ingredients[0], currentRecipeMap, leftPath, depth+1)
+				leftResults := dfsSubTree(pair[0], currentRecipeMap, leftPath, depth+1, opts)
 				
 				if len(leftResults) == 0 {
 					return
 				}
 				
 				rightPath := copyVisitedMap(currentPath)
-				rightResults := dfsSubTree(ctx, ingredients[1], currentRecipeMap, rightPath, depth+1)
+				rightResults := dfsSubTree(pair[1], currentRecipeMap, rightPath, depth+1, opts)
 				
 				if len(rightResults) == 0 {
 					return
@@ -288,16 +253,16 @@ func dfsSubTree(ctx context.Context, element string, currentRecipeMap map[string
 					}
 				}
 				
-				if len(localNodes) > 0 {
-					resultsMutex.Lock()
-					allPossibleNodesForElement = append(allPossibleNodesForElement, localNodes...)
-					resultsMutex.Unlock()
-				}
-			}(pair)
+				nodesPerPair[pairIndex] = localNodes
+			})
 		}
 		
-		wg.Wait()
+		group.wait()
 		
+		var allPossibleNodesForElement []*Node
+		for _, localNodes := range nodesPerPair {
+			allPossibleNodesForElement = append(allPossibleNodesForElement, localNodes...)
+		}
 		if len(allPossibleNodesForElement) > 5 {
 			return allPossibleNodesForElement[:5]
 		}
@@ -316,13 +281,13 @@ func dfsSubTree(ctx context.Context, element string, currentRecipeMap map[string
 			}
 
 			leftPath := copyVisitedMap(currentPath)
-			leftIngredientOptions := dfsSubTree(ctx, pair[0], currentRecipeMap, leftPath, depth+1)
+			leftIngredientOptions := dfsSubTree(pair[0], currentRecipeMap, leftPath, depth+1, opts)
 			if len(leftIngredientOptions) == 0 {
 				continue
 			}
 			
 			rightPath := copyVisitedMap(currentPath)
-			rightIngredientOptions := dfsSubTree(ctx, pair[1], currentRecipeMap, rightPath, depth+1)
+			rightIngredientOptions := dfsSubTree(pair[1], currentRecipeMap, rightPath, depth+1, opts)
 			if len(rightIngredientOptions) == 0 {
 				continue
 			}
@@ -384,30 +349,4 @@ func copyVisitedMap(original map[string]bool) map[string]bool {
 		copied[k] = v
 	}
 	return copied
-} 									
-
-
-// func main() {
-// 	loadRecipes("recipes.json")
-// 	target := "Librarian"
-// 	numOfRecipe := 2
-
-// 	// ini buat debug result aja
-// 	tree := InitTree(target, recipeData.Recipes[target])
-// 	printTree(tree)
-
-// 	// Try Single Recipe
-// 	// result, nodes := searchDFSOne(target)
-// 	// printTree(result)
-// 	// if result.root == nil {
-// 	// 	fmt.Println("root is nil")
-// 	// }
-// 	// fmt.Printf("Number of visited nodes: %d\n", nodes)
-
-// 	// Try multiple Recipe
-// 	result2, nodes2 := searchDFSMultiple(target, numOfRecipe)
-// 	for _, recipe := range result2 {
-// 		printTree(recipe)
-// 	}
-// 	fmt.Printf("Number of visited nodes: %d\n", nodes2)
-// }
\ No newline at end of file
+}
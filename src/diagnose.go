@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*** SEARCH FAILURE DIAGNOSTICS ***/
+
+// searchTimeout bounds how long a request waits for its search. The search is
+// then cancelled, see SearchOptions.cancelled.
+const searchTimeout = 30 * time.Second
+
+// Codes of a SearchError, sent as "code" in the error response.
+const (
+	errUnknownElement = "unknown_element" // not in the dataset
+	errNoRecipes      = "no_recipes"      // the element, or something it needs, has no recipe
+	errCyclicRecipes  = "cyclic_recipes"  // every recipe eventually needs the element itself
+	errUnsatisfiable  = "unsatisfiable"   // inventory, exclude or require rule out every tree
+	errNotFound       = "not_found"       // the algorithm returned no complete tree
+	errTimeout        = "timeout"
+	errSearchFailed   = "search_failed" // the algorithm crashed
+	errInvalidTree    = "invalid_tree"  // debug mode: a returned tree failed verifyTree
+	errInternal       = "internal"      // any other failure, not caused by the request
+)
+
+// SearchError explains why a search has no answer. Element is the element the
+// problem is about, which is not always the target.
+type SearchError struct {
+	Code        string
+	Element     string
+	Message     string
+	Suggestions []string     // errUnknownElement: elements Element may be a typo of
+	Fields      []FieldError // errInvalidRequest: the fields that can't be searched together
+}
+
+func (e *SearchError) Error() string {
+	return e.Message
+}
+
+func (e *SearchError) status() int {
+	switch e.Code {
+	case errInvalidRequest:
+		return http.StatusBadRequest
+	case errUnknownElement:
+		return http.StatusNotFound
+	case errTimeout:
+		return http.StatusGatewayTimeout
+	case errSearchFailed, errInvalidTree, errInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// writeSearchError answers with the typed error response of err.
+func writeSearchError(w http.ResponseWriter, err error) {
+	status, resp := searchErrorResponse(err)
+	writeJSON(w, status, resp)
+}
+
+// searchErrorResponse is the status and typed error response of err. An error
+// that isn't a *SearchError is unexpected and answers as errInternal.
+func searchErrorResponse(err error) (int, ErrorResponse) {
+	var searchErr *SearchError
+	if !errors.As(err, &searchErr) {
+		searchErr = &SearchError{Code: errInternal, Message: err.Error()}
+	}
+	return searchErr.status(), ErrorResponse{
+		Error:       searchErr.Message,
+		Code:        searchErr.Code,
+		Element:     searchErr.Element,
+		Suggestions: searchErr.Suggestions,
+		Fields:      searchErr.Fields,
+	}
+}
+
+// diagnoseTarget checks that target can be made at all under opts before any
+// algorithm runs. It forward-chains from the leaves, and for an unreachable
+// target follows what blocks it to an element without recipes or a cycle.
+func diagnoseTarget(target string, opts SearchOptions) error {
+	if lookupElement(target) == nil {
+		return unknownElementError(target)
+	}
+	if err := opts.validate(target); err != nil {
+		return &SearchError{Code: errUnsatisfiable, Element: target, Message: err.Error()}
+	}
+	if opts.isLeaf(target) {
+		return nil
+	}
+
+	recipes := opts.recipesFor(target)
+	if len(recipes[target]) == 0 {
+		return &SearchError{Code: errNoRecipes, Element: target, Message: target + " has no recipes"}
+	}
+	var leaves []string
+	for _, combs := range recipes {
+		for _, pair := range combs {
+			for _, ingredient := range pair {
+				if opts.isLeaf(ingredient) {
+					leaves = append(leaves, ingredient)
+				}
+			}
+		}
+	}
+	closure := computeClosure(leaves, []string{target}, recipes)
+	if len(closure.Unreachable) == 0 {
+		return nil
+	}
+
+	cause := closure.Unreachable[0].Cause
+	if len(recipes[cause]) == 0 {
+		return &SearchError{Code: errNoRecipes, Element: cause,
+			Message: fmt.Sprintf("%s can't be made: it needs %s, which has no recipes", target, cause)}
+	}
+	return &SearchError{Code: errCyclicRecipes, Element: cause,
+		Message: fmt.Sprintf("%s can't be made: every recipe for %s needs %s itself", target, cause, cause)}
+}
+
+// dispatchWithTimeout runs dispatchSearch, turning a crash into
+// errSearchFailed and a search slower than searchTimeout into errTimeout. The
+// search is cancelled when it times out, and the partial result it stops with
+// is never returned.
+func dispatchWithTimeout(req SearchRequest, opts SearchOptions) (searchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
+	opts.ctx = ctx
+
+	type outcome struct {
+		result searchResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Search for %s panicked: %v\n", req.Target, r)
+				done <- outcome{err: &SearchError{Code: errSearchFailed, Element: req.Target,
+					Message: fmt.Sprintf("search for %s failed: %v", req.Target, r)}}
+			}
+		}()
+		done <- outcome{result: dispatchSearch(req, opts)}
+	}()
+
+	select {
+	case o := <-done:
+		if ctx.Err() == nil {
+			return o.result, o.err
+		}
+	case <-ctx.Done():
+	}
+	return searchResult{}, &SearchError{Code: errTimeout, Element: req.Target,
+		Message: fmt.Sprintf("search for %s timed out after %s", req.Target, searchTimeout)}
+}
+
+// searchGroup runs the goroutines of one search. A panic in any of them is
+// raised again by wait, in the goroutine that started them, so
+// dispatchWithTimeout recovers it instead of the server going down.
+type searchGroup struct {
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	recovered interface{}
+}
+
+func (g *searchGroup) spawn(f func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.mu.Lock()
+				if g.recovered == nil {
+					g.recovered = r
+				}
+				g.mu.Unlock()
+			}
+		}()
+		f()
+	}()
+}
+
+// wait waits for every goroutine and panics with the first panic among them.
+func (g *searchGroup) wait() {
+	g.wg.Wait()
+	if g.recovered != nil {
+		panic(g.recovered)
+	}
+}
+
+// dropIncomplete removes nil trees and trees with a leaf that is neither a base
+// element nor owned, which some algorithms return when they give up halfway.
+// The leaves the dropped trees stopped at are kept for a warning. When no tree
+// is left it fails with what blocks the first of them, if anything does.
+func dropIncomplete(target string, result searchResult, opts SearchOptions) (searchResult, error) {
+	var unfinished []string
+	kept := result.keepTrees(func(tree *TreeNode) bool {
+		if tree == nil {
+			return false
+		}
+		if leaf := incompleteLeaf(tree, opts); leaf != "" {
+			unfinished = append(unfinished, leaf)
+			return false
+		}
+		return true
+	})
+	if len(kept.trees) > 0 {
+		kept.unfinished = unfinished
+		return kept, nil
+	}
+
+	if len(unfinished) == 0 {
+		return result, &SearchError{Code: errNotFound, Element: target, Message: "the search found no recipe tree for " + target}
+	}
+	leaf := unfinished[0]
+	var cause *SearchError
+	if errors.As(diagnoseTarget(leaf, opts), &cause) {
+		return result, &SearchError{Code: cause.Code, Element: cause.Element,
+			Message: fmt.Sprintf("the search stopped at %s: %s", leaf, cause.Message)}
+	}
+	return result, &SearchError{Code: errNotFound, Element: target,
+		Message: fmt.Sprintf("the search stopped at %s without reaching base elements", leaf)}
+}
+
+// dropDuplicates keeps the first of trees that only differ in the order of
+// some recipe's ingredients, which datasets listing a pair both ways produce.
+func dropDuplicates(result searchResult) searchResult {
+	seen := make(map[string]bool)
+	return result.keepTrees(func(tree *TreeNode) bool {
+		signature := canonicalTree(tree)
+		if seen[signature] {
+			return false
+		}
+		seen[signature] = true
+		return true
+	})
+}
+
+// canonicalTree writes a tree with the children of every node sorted, like
+// serializeTree does for the multiple DFS, so mirrored pairs compare equal.
+func canonicalTree(node *TreeNode) string {
+	if node == nil {
+		return ""
+	}
+	if len(node.Children) == 0 {
+		return node.Name
+	}
+	children := make([]string, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = canonicalTree(child)
+	}
+	sort.Strings(children)
+	return node.Name + "(" + strings.Join(children, ",") + ")"
+}
+
+// incompleteLeaf returns the first leaf of tree that still needs crafting.
+func incompleteLeaf(node *TreeNode, opts SearchOptions) string {
+	if node == nil {
+		return ""
+	}
+	if len(node.Children) == 0 && !opts.isLeaf(node.Name) {
+		return node.Name
+	}
+	for _, child := range node.Children {
+		if leaf := incompleteLeaf(child, opts); leaf != "" {
+			return leaf
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSearchErrorResponse(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&SearchError{Code: errCyclicRecipes, Element: "Phoenix", Message: "cyclic"}, http.StatusUnprocessableEntity, errCyclicRecipes},
+		{&SearchError{Code: errTimeout, Message: "slow"}, http.StatusGatewayTimeout, errTimeout},
+		{&SearchError{Code: errInvalidRequest, Message: "invalid"}, http.StatusBadRequest, errInvalidRequest},
+		{errors.New("disk on fire"), http.StatusInternalServerError, errInternal},
+	}
+	for _, c := range cases {
+		status, resp := searchErrorResponse(c.err)
+		if status != c.status || resp.Code != c.code || resp.Error != c.err.Error() {
+			t.Errorf("%v: got %d %+v, want %d %s", c.err, status, resp, c.status, c.code)
+		}
+	}
+}
+
+func TestSearchGroupPanics(t *testing.T) {
+	var g searchGroup
+	var done int32
+	for i := 0; i < 4; i++ {
+		i := i
+		g.spawn(func() {
+			if i == 2 {
+				panic("boom")
+			}
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want boom", r)
+		}
+		if done != 3 {
+			t.Errorf("%d goroutines finished before wait panicked, want 3", done)
+		}
+	}()
+	g.wait()
+	t.Error("wait returned without panicking")
+}
+
+func TestCancelledSearchStops(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := SearchOptions{ctx: ctx}
+	for _, algorithm := range []string{"BFS", "DFS", "Bidirectional"} {
+		for _, mode := range []string{"single", "multiple"} {
+			req := SearchRequest{Target: "Brick", Algorithm: algorithm, SearchMode: mode, MaxRecipes: 3}
+			result := dispatchSearch(req, opts)
+			if trees := completeTrees(result.trees, opts); trees > 0 {
+				t.Errorf("%s %s: a cancelled search returned %d complete trees", algorithm, mode, trees)
+			}
+		}
+	}
+}
+
+func completeTrees(trees []*TreeNode, opts SearchOptions) int {
+	n := 0
+	for _, tree := range trees {
+		if tree != nil && incompleteLeaf(tree, opts) == "" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDropIncomplete(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	leaf := func(name string) *TreeNode { return &TreeNode{Name: name} }
+	node := func(name string, a, b *TreeNode) *TreeNode { return &TreeNode{Name: name, Children: []*TreeNode{a, b}} }
+	mud := node("Mud", leaf("Water"), leaf("Earth"))
+
+	// a complete tree is kept, the unfinished ones are named in a warning
+	result := searchResult{kind: multipleResult, want: 3, nodesVisited: []int{3, 2, 2}, trees: []*TreeNode{
+		node("Brick", mud, leaf("Fire")),
+		node("Brick", leaf("Mud"), leaf("Fire")),
+		node("Brick", leaf("Mud"), leaf("Fire")),
+	}}
+	kept, err := dropIncomplete("Brick", result, SearchOptions{})
+	if err != nil || len(kept.trees) != 1 {
+		t.Fatalf("got %d trees, %v", len(kept.trees), err)
+	}
+	warnings := searchWarnings(SearchRequest{Target: "Brick", Algorithm: "BFS", SearchMode: "multiple", MaxRecipes: 3}, kept)
+	want := "dropped 2 trees the search left unfinished at Mud"
+	if len(warnings) == 0 || warnings[0] != want {
+		t.Errorf("warnings %q, want %q first", warnings, want)
+	}
+
+	// without a complete tree the error says what blocks the unfinished leaf
+	cases := []struct {
+		tree          *TreeNode
+		code, element string
+	}{
+		{node("Blackhole", leaf("Void"), leaf("Stone")), errNoRecipes, "Void"},
+		{node("Phoenix", leaf("Phoenix"), leaf("Fire")), errCyclicRecipes, "Phoenix"},
+		{node("Brick", leaf("Mud"), leaf("Fire")), errNotFound, "Brick"},
+	}
+	for _, c := range cases {
+		_, err := dropIncomplete(c.tree.Name, searchResult{kind: singleResult, trees: []*TreeNode{c.tree}, nodesVisited: []int{1}}, SearchOptions{})
+		var searchErr *SearchError
+		if !errors.As(err, &searchErr) || searchErr.Code != c.code || searchErr.Element != c.element {
+			t.Errorf("%s: got %v, want %s about %s", canonicalTree(c.tree), err, c.code, c.element)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*** ELEMENT CATALOGUE ***/
+
+// ElementSummary is one entry of the element list.
+type ElementSummary struct {
+	Name    string `json:"name"`
+	Tier    int    `json:"tier"`    // -1 if it can't be made
+	Recipes int    `json:"recipes"` // recipes making the element
+	Uses    int    `json:"uses"`    // recipes the element is an ingredient of
+}
+
+type ElementListResponse struct {
+	Elements   []ElementSummary `json:"elements"`
+	Total      int              `json:"total"` // elements matching the filters, on every page
+	NextCursor string           `json:"nextCursor,omitempty"`
+	HasMore    bool             `json:"hasMore"`
+}
+
+type ElementCounts struct {
+	Recipes  int `json:"recipes"`
+	Uses     int `json:"uses"`
+	Products int `json:"products"` // distinct elements the element is used to make
+	MinTree  int `json:"minTree"`  // nodes in the smallest recipe tree, 0 if it can't be made
+}
+
+type ElementDetail struct {
+	Name    string        `json:"name"`
+	Tier    int           `json:"tier"`
+	Base    bool          `json:"base"`
+	Recipes [][]string    `json:"recipes"`
+	Uses    []Use         `json:"uses"`
+	Counts  ElementCounts `json:"counts"`
+}
+
+func summarizeElement(name string) ElementSummary {
+	info := lookupElement(name)
+	return ElementSummary{Name: name, Tier: info.Tier, Recipes: len(info.Recipes), Uses: len(info.Uses)}
+}
+
+// filterElements lists the elements, sorted by name, whose name contains
+// search and starts with prefix, both ignoring case, and that are of tier
+// unless tier is nil.
+func filterElements(search, prefix string, tier *int) []string {
+	search, prefix = strings.ToLower(search), strings.ToLower(prefix)
+	var matches []string
+	for _, name := range searchIndex.names {
+		folded := strings.ToLower(name)
+		if !strings.Contains(folded, search) || !strings.HasPrefix(folded, prefix) {
+			continue
+		}
+		if tier != nil && lookupElement(name).Tier != *tier {
+			continue
+		}
+		matches = append(matches, name)
+	}
+	return matches
+}
+
+// GET /api/elements?q=stone&prefix=s&tier=2&limit=10&cursor=...
+func elementsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var tier *int
+	if raw := query.Get("tier"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, `{"error":"invalid tier"}`, http.StatusBadRequest)
+			return
+		}
+		tier = &n
+	}
+	limit := defaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	cursor, err := decodeCursor(query.Get("cursor"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+		return
+	}
+
+	matches := filterElements(query.Get("q"), query.Get("prefix"), tier)
+	start := len(matches)
+	if cursor < uint64(len(matches)) {
+		start = int(cursor)
+	}
+	end := min(start+limit, len(matches))
+	resp := ElementListResponse{Elements: []ElementSummary{}, Total: len(matches), HasMore: end < len(matches)}
+	for _, name := range matches[start:end] {
+		resp.Elements = append(resp.Elements, summarizeElement(name))
+	}
+	if resp.HasMore {
+		resp.NextCursor = encodeCursor(uint64(end))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /api/elements/{name}, name in any case and spacing.
+func elementHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := canonicalName(r.PathValue("name"))
+	if !ok {
+		writeSearchError(w, unknownElementError(r.PathValue("name")))
+		return
+	}
+	log.Printf("Describing element '%s'\n", name)
+
+	info := lookupElement(name)
+	resp := ElementDetail{
+		Name:    name,
+		Tier:    info.Tier,
+		Base:    info.Tier == 0,
+		Recipes: info.Recipes,
+		Uses:    info.Uses,
+		Counts:  ElementCounts{Recipes: len(info.Recipes), Uses: len(info.Uses), MinTree: info.MinSize},
+	}
+	if resp.Recipes == nil {
+		resp.Recipes = [][]string{}
+	}
+	if resp.Uses == nil {
+		resp.Uses = []Use{}
+	}
+	products := make(map[string]bool)
+	for _, use := range info.Uses {
+		products[use.Result] = true
+	}
+	resp.Counts.Products = len(products)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// getJSON serves GET path through the element catalogue routes, validation
+// included, and decodes the answer into resp.
+func getJSON(t *testing.T, path string, resp interface{}) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/elements", validated("/api/elements", elementsHandler))
+	mux.HandleFunc("/api/elements/{name}", validated("/api/elements/{name}", elementHandler))
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), resp); err != nil {
+		t.Fatalf("GET %s: %v in %s", path, err, recorder.Body)
+	}
+	return recorder.Code
+}
+
+func TestElementList(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	names := func(query string) []string {
+		var all []string
+		cursor := ""
+		for {
+			var page ElementListResponse
+			if status := getJSON(t, "/api/elements?limit=4&"+query+"&cursor="+cursor, &page); status != http.StatusOK {
+				t.Fatalf("%s: status %d", query, status)
+			}
+			for _, element := range page.Elements {
+				all = append(all, element.Name)
+			}
+			if !page.HasMore {
+				if page.Total != len(all) {
+					t.Errorf("%s: total %d, paged through %d", query, page.Total, len(all))
+				}
+				return all
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"prefix=b", []string{"Blackhole", "Brick"}},
+		{"prefix=B", []string{"Blackhole", "Brick"}},
+		{"q=AR", []string{"Earth"}},
+		{"q=e&tier=1", []string{"Energy", "Steam"}},
+		{"tier=-1", []string{"Blackhole", "Phoenix", "Void"}},
+		{"tier=0", []string{"Air", "Earth", "Fire", "Water"}},
+		{"prefix=zz", nil},
+	}
+	for _, c := range cases {
+		if got := names(c.query); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.query, got, c.want)
+		}
+	}
+	if got := names(""); len(got) != len(recipeData.Elements) {
+		t.Errorf("listed %d elements, the fixture has %d", len(got), len(recipeData.Elements))
+	}
+}
+
+func TestElementDetail(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	var mud ElementDetail
+	if status := getJSON(t, "/api/elements/mUD", &mud); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	want := ElementDetail{
+		Name:    "Mud",
+		Tier:    1,
+		Recipes: [][]string{{"Water", "Earth"}},
+		Uses:    mud.Uses,
+		Counts:  ElementCounts{Recipes: 1, Uses: 3, Products: 2, MinTree: 3},
+	}
+	if !reflect.DeepEqual(mud, want) {
+		t.Errorf("got %+v, want %+v", mud, want)
+	}
+
+	var resp ErrorResponse
+	if status := getJSON(t, "/api/elements/Mudd", &resp); status != http.StatusNotFound || resp.Code != errUnknownElement {
+		t.Errorf("unknown element: got %d %+v", status, resp)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*** DETERMINISTIC TREE ENUMERATION ***/
+
+// Trees are listed by size (number of nodes), then by recipe pair name, then
+// recursively by the ingredient subtrees. The order only depends on the
+// dataset, so a cursor handed out for one page stays valid for the next one.
+
+// enumSizeSlack bounds how much bigger than the smallest tree an enumerated
+// tree may be. Without a bound the listing never ends once recipes form cycles.
+const enumSizeSlack = 64
+
+// enumScanFactor limits how many raw candidates a page may skip (trees where
+// an element is its own ancestor, or missing a required element) before it is
+// returned short.
+const enumScanFactor = 50
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+type treeEnumerator struct {
+	target  string
+	opts    SearchOptions
+	recipes map[string][][2]string
+	minSize map[string]int
+	maxSize int
+	counts  map[enumKey]uint64
+}
+
+type enumKey struct {
+	element string
+	size    int
+}
+
+func newTreeEnumerator(target string, opts SearchOptions) *treeEnumerator {
+	e := &treeEnumerator{
+		target:  target,
+		opts:    opts,
+		recipes: make(map[string][][2]string),
+		minSize: make(map[string]int),
+		counts:  make(map[enumKey]uint64),
+	}
+
+	if opts.changesRecipes() {
+		for element, combs := range opts.recipesFor(target) {
+			e.recipes[element] = normalisePairs(combs)
+		}
+		e.computeMinSize()
+	} else {
+		// the index already holds the normalised pairs and smallest sizes
+		for element := range opts.recipesFor(target) {
+			e.recipes[element] = lookupElement(element).Pairs
+		}
+		e.minSize = searchIndex.minSize
+	}
+	if size, ok := e.minSizeOf(target); ok {
+		e.maxSize = size + enumSizeSlack
+	}
+	return e
+}
+
+// minSizeOf is the smallest tree size of element, false if it can't be made.
+func (e *treeEnumerator) minSizeOf(element string) (int, bool) {
+	if _, hasRecipe := e.recipes[element]; !hasRecipe {
+		return 1, e.opts.isLeaf(element)
+	}
+	size, ok := e.minSize[element]
+	return size, ok
+}
+
+// computeMinSize relaxes every recipe until the smallest sizes stop changing.
+func (e *treeEnumerator) computeMinSize() {
+	changed := true
+	for changed {
+		changed = false
+		for element, pairs := range e.recipes {
+			for _, p := range pairs {
+				s1, ok1 := e.minSizeOf(p[0])
+				s2, ok2 := e.minSizeOf(p[1])
+				if !ok1 || !ok2 {
+					continue
+				}
+				if cur, ok := e.minSize[element]; !ok || 1+s1+s2 < cur {
+					e.minSize[element] = 1 + s1 + s2
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func satAdd(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func satMul(a, b uint64) uint64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > math.MaxUint64/b {
+		return math.MaxUint64
+	}
+	return a * b
+}
+
+// count returns the number of trees of the given (odd) size rooted at element.
+// Counts saturate at MaxUint64, which is far beyond any reachable cursor.
+func (e *treeEnumerator) count(element string, size int) uint64 {
+	smallest, ok := e.minSizeOf(element)
+	if !ok || size < smallest || size%2 == 0 {
+		return 0
+	}
+	pairs, hasRecipe := e.recipes[element]
+	if !hasRecipe {
+		if size == 1 {
+			return 1
+		}
+		return 0
+	}
+
+	key := enumKey{element, size}
+	if c, ok := e.counts[key]; ok {
+		return c
+	}
+
+	var total uint64
+	for _, p := range pairs {
+		minA, minB, ok := e.pairMinSizes(p)
+		if !ok {
+			continue
+		}
+		for sa := minA; sa <= size-1-minB; sa += 2 {
+			sb := size - 1 - sa
+			if p[0] == p[1] && sa > sb {
+				break
+			}
+			total = satAdd(total, e.blockSize(p, sa, sb))
+		}
+	}
+	e.counts[key] = total
+	return total
+}
+
+// pairMinSizes returns the smallest tree sizes of both ingredients of p. Only
+// ingredient sizes from there on have trees, so count and unrank start there.
+func (e *treeEnumerator) pairMinSizes(p [2]string) (int, int, bool) {
+	minA, okA := e.minSizeOf(p[0])
+	minB, okB := e.minSizeOf(p[1])
+	return minA, minB, okA && okB
+}
+
+// blockSize counts the trees using pair p with ingredient sizes sa and sb. When
+// both ingredients are the same element the two subtrees are unordered.
+func (e *treeEnumerator) blockSize(p [2]string, sa, sb int) uint64 {
+	ca := e.count(p[0], sa)
+	if ca == 0 {
+		return 0
+	}
+	if p[0] == p[1] && sa == sb {
+		if ca%2 == 0 {
+			return satMul(ca/2, satAdd(ca, 1))
+		}
+		return satMul(ca, satAdd(ca, 1)/2)
+	}
+	return satMul(ca, e.count(p[1], sb))
+}
+
+// unrank builds the rank-th tree of the given size rooted at element. It
+// reports false when the tree uses an element as its own ancestor.
+func (e *treeEnumerator) unrank(element string, size int, rank uint64, path map[string]bool) (*Node, bool) {
+	if path[element] {
+		return nil, false
+	}
+	node := &Node{element: element}
+	if size == 1 {
+		return node, true
+	}
+
+	path[element] = true
+	defer delete(path, element)
+
+	for _, p := range e.recipes[element] {
+		minA, minB, ok := e.pairMinSizes(p)
+		if !ok {
+			continue
+		}
+		for sa := minA; sa <= size-1-minB; sa += 2 {
+			sb := size - 1 - sa
+			if p[0] == p[1] && sa > sb {
+				break
+			}
+			block := e.blockSize(p, sa, sb)
+			if rank >= block {
+				rank -= block
+				continue
+			}
+
+			var i, j uint64
+			if p[0] == p[1] && sa == sb {
+				// unordered pair (i <= j), row by row
+				c := e.count(p[0], sa)
+				for i = 0; rank >= c-i; i++ {
+					rank -= c - i
+				}
+				j = i + rank
+			} else {
+				cb := e.count(p[1], sb)
+				i, j = rank/cb, rank%cb
+			}
+
+			left, ok := e.unrank(p[0], sa, i, path)
+			if !ok {
+				return nil, false
+			}
+			right, ok := e.unrank(p[1], sb, j, path)
+			if !ok {
+				return nil, false
+			}
+			node.combinations = []Recipe{{ingredient1: left, ingredient2: right}}
+			return node, true
+		}
+	}
+	return nil, false
+}
+
+// at returns the tree at a global position of the listing. The second result is
+// false for cyclic candidates and the third is false past the end.
+func (e *treeEnumerator) at(pos uint64) (*Node, bool, bool) {
+	smallest, ok := e.minSizeOf(e.target)
+	if !ok {
+		return nil, false, false
+	}
+	for size := smallest; size <= e.maxSize; size += 2 {
+		c := e.count(e.target, size)
+		if pos < c {
+			node, valid := e.unrank(e.target, size, pos, make(map[string]bool))
+			return node, valid, true
+		}
+		pos -= c
+	}
+	return nil, false, false
+}
+
+// enumPage is one page of the listing. next is the position the page reached,
+// past every candidate it looked at, so the next page never scans them again.
+type enumPage struct {
+	roots   []*Node
+	next    uint64
+	more    bool
+	skipped int // cyclic candidates and trees missing a required element
+}
+
+// short reports whether the page ran out of candidates to scan before it was
+// full, with trees left beyond it.
+func (p enumPage) short(limit int) bool {
+	return p.more && len(p.roots) < limit
+}
+
+// page lists up to limit trees starting at cursor.
+func (e *treeEnumerator) page(cursor uint64, limit int) enumPage {
+	p := enumPage{next: cursor}
+	for scanned := 0; len(p.roots) < limit && scanned < limit*enumScanFactor; scanned++ {
+		node, valid, exists := e.at(p.next)
+		if !exists {
+			return p
+		}
+		p.next++
+		if !valid {
+			p.skipped++
+			continue
+		}
+		if len(e.opts.Require) > 0 {
+			elements := make(map[string]bool)
+			collectNodeElements(node, elements)
+			if !e.opts.hasRequired(elements) {
+				p.skipped++
+				continue
+			}
+		}
+		p.roots = append(p.roots, node)
+	}
+	_, _, p.more = e.at(p.next)
+	return p
+}
+
+func enumerateTrees(target string, cursor uint64, limit int, opts SearchOptions) ([]*Tree, []int, enumPage) {
+	enumerator := newTreeEnumerator(target, opts)
+	page := enumerator.page(cursor, limit)
+
+	var trees []*Tree
+	var pathElementCounts []int
+	for _, root := range page.roots {
+		trees = append(trees, &Tree{root: root})
+		pathElementCounts = append(pathElementCounts, getPathElementCount(root))
+	}
+	return trees, pathElementCounts, page
+}
+
+func encodeCursor(pos uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(pos, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
+
+type EnumerateResponse struct {
+	Trees         []*TreeNode `json:"trees"`
+	NodesVisited  []int       `json:"nodesVisited"`
+	NextCursor    string      `json:"nextCursor,omitempty"`
+	HasMore       bool        `json:"hasMore"`
+	Short         bool        `json:"short"`
+	Skipped       int         `json:"skipped"`
+	ExecutionTime float64     `json:"executionTime"`
+}
+
+// GET /api/enumerate?target=Brick&limit=10&cursor=...&inventory=Mud,Stone&exclude=Fire&require=Mud
+func enumerateHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	target := query.Get("target")
+	if target == "" {
+		http.Error(w, `{"error":"missing target"}`, http.StatusBadRequest)
+		return
+	}
+	target = resolveName(target)
+
+	limit := defaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	cursor, err := decodeCursor(query.Get("cursor"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Enumerating trees for '%s' from cursor %d, limit %d\n", target, cursor, limit)
+	startTime := time.Now()
+
+	opts := newSearchOptions(SearchRequest{
+		Target:    target,
+		Inventory: splitElementList(query.Get("inventory")),
+		Exclude:   splitElementList(query.Get("exclude")),
+		Require:   splitElementList(query.Get("require")),
+	})
+	if err := diagnoseTarget(target, opts); err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	trees, nodeVisited, page := enumerateTrees(target, cursor, limit, opts)
+	treeNodes := []*TreeNode{}
+	for _, tree := range trees {
+		treeNodes = append(treeNodes, convertToTreeNode(tree.root))
+	}
+
+	resp := EnumerateResponse{
+		Trees:         treeNodes,
+		NodesVisited:  nodeVisited,
+		HasMore:       page.more,
+		Short:         page.short(limit),
+		Skipped:       page.skipped,
+		ExecutionTime: float64(time.Since(startTime).Milliseconds()),
+	}
+	if page.more {
+		resp.NextCursor = encodeCursor(page.next)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
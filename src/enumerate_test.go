@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestEnumerateOrder checks that the listing is smallest first, without
+// duplicates and with valid trees only.
+func TestEnumerateOrder(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	for _, target := range []string{"Brick", "House", "Egg"} {
+		page := newTreeEnumerator(target, SearchOptions{}).page(0, maxPageSize)
+		if len(page.roots) < 3 {
+			t.Fatalf("%s: got %d trees", target, len(page.roots))
+		}
+		seen := make(map[string]bool)
+		previous := 0
+		for i, root := range page.roots {
+			tree := convertToTreeNode(root)
+			size := countNodes(root)
+			if size < previous {
+				t.Errorf("%s: tree %d has %d nodes, after one with %d", target, i, size, previous)
+			}
+			previous = size
+			if seen[canonicalTree(tree)] {
+				t.Errorf("%s: tree %d is listed twice: %s", target, i, canonicalTree(tree))
+			}
+			seen[canonicalTree(tree)] = true
+			if problems := verifyTree(tree, SearchOptions{}); len(problems) > 0 {
+				t.Errorf("%s: tree %d is invalid: %v", target, i, problems)
+			}
+		}
+	}
+}
+
+// TestEnumerateCursors checks that walking the listing page by page gives the
+// same trees as one big page, whatever the page size.
+func TestEnumerateCursors(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	for _, target := range []string{"House", "Stone"} {
+		enumerator := newTreeEnumerator(target, SearchOptions{})
+		want := enumerator.page(0, 60)
+		for _, limit := range []int{1, 3, 7} {
+			var got []*Node
+			cursor, more := uint64(0), true
+			for more && len(got) <= len(want.roots) {
+				page := enumerator.page(cursor, limit)
+				if page.next < cursor {
+					t.Fatalf("%s by %d: the cursor went back from %d to %d", target, limit, cursor, page.next)
+				}
+				got = append(got, page.roots...)
+				cursor, more = page.next, page.more
+			}
+			if !want.more && len(got) != len(want.roots) {
+				t.Errorf("%s by %d: got %d trees, want %d", target, limit, len(got), len(want.roots))
+			}
+			if len(got) < len(want.roots) {
+				t.Fatalf("%s by %d: got %d trees, want at least %d", target, limit, len(got), len(want.roots))
+			}
+			for i := range want.roots {
+				if canonicalTree(convertToTreeNode(got[i])) != canonicalTree(convertToTreeNode(want.roots[i])) {
+					t.Errorf("%s by %d: tree %d differs from the one in a single page", target, limit, i)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestEnumerateShortPages(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	// Stone has two trees, the rest of its listing loops through Stone itself
+	stone := newTreeEnumerator("Stone", SearchOptions{})
+	page := stone.page(0, 10)
+	if len(page.roots) != 2 || page.more || page.short(10) || page.skipped != int(page.next)-2 {
+		t.Errorf("Stone: got %d trees, next %d, more %v, skipped %d", len(page.roots), page.next, page.more, page.skipped)
+	}
+	if page = stone.page(page.next, 10); len(page.roots) != 0 || page.more {
+		t.Errorf("Stone past the end: got %d trees, more %v", len(page.roots), page.more)
+	}
+
+	// no early Egg tree contains Chicken, so the pages run out of candidates
+	opts := newSearchOptions(SearchRequest{Target: "Egg", Require: []string{"Chicken"}})
+	egg := newTreeEnumerator("Egg", opts)
+	var cursor uint64
+	for i := 0; i < 3; i++ {
+		page := egg.page(cursor, 2)
+		if !page.short(2) || page.skipped != 2*enumScanFactor || page.next != cursor+2*enumScanFactor {
+			t.Errorf("Egg page %d: got %d trees, next %d, more %v, skipped %d", i, len(page.roots), page.next, page.more, page.skipped)
+		}
+		cursor = page.next
+	}
+
+	recorder := httptest.NewRecorder()
+	enumerateHandler(recorder, httptest.NewRequest("GET", "/api/enumerate?target=Egg&limit=2&require=Chicken", nil))
+	var resp EnumerateResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("got %d %s", recorder.Code, recorder.Body)
+	}
+	if !resp.Short || !resp.HasMore || resp.Skipped != 2*enumScanFactor || resp.NextCursor != encodeCursor(2*enumScanFactor) {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func countNodes(node *Node) int {
+	if len(node.combinations) == 0 {
+		return 1
+	}
+	recipe := node.combinations[0]
+	return 1 + countNodes(recipe.ingredient1) + countNodes(recipe.ingredient2)
+}
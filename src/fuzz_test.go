@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// fuzzDeadline bounds one request in the fuzz tests. The fixture is tiny, so a
+// request running this long is stuck, well before searchTimeout would end it.
+const fuzzDeadline = 10 * time.Second
+
+// serveSearch posts body to /api/search, validation included, on the fixture
+// dataset and checks the answer: it comes within fuzzDeadline, is JSON, and is
+// either a result or an error response with a client error status. A 5xx means
+// a bad request got through to a search that then crashed or hung.
+func serveSearch(t *testing.T, body []byte) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() { done <- recover() }()
+		validated("/api/search", searchHandler)(recorder, httptest.NewRequest("POST", "/api/search", bytes.NewReader(body)))
+	}()
+	select {
+	case p := <-done:
+		if p != nil {
+			t.Fatalf("handler panicked on %q: %v", body, p)
+		}
+	case <-time.After(fuzzDeadline):
+		t.Fatalf("handler did not answer %q within %s", body, fuzzDeadline)
+	}
+
+	status, data := recorder.Code, recorder.Body.Bytes()
+	if !json.Valid(data) {
+		t.Fatalf("status %d, response to %q is not JSON: %q", status, body, data)
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("status %d, response to %q is not an object: %q", status, body, data)
+	}
+	switch {
+	case status == http.StatusOK:
+		if _, ok := resp["error"]; ok {
+			t.Fatalf("status 200 with an error for %q: %s", body, data)
+		}
+		_, single := resp["tree"]
+		_, multiple := resp["trees"]
+		if !single && !multiple {
+			t.Fatalf("status 200 without trees for %q: %s", body, data)
+		}
+	case status >= 400 && status < 500:
+		if message, _ := resp["error"].(string); message == "" {
+			t.Fatalf("status %d without an error message for %q: %s", status, body, data)
+		}
+	default:
+		t.Fatalf("status %d for %q: %s", status, body, data)
+	}
+}
+
+// fuzzSetup loads the fixture and silences the handler's request logging.
+func fuzzSetup(f *testing.F) {
+	loadDataset(f, "testdata/fixture.json")
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func FuzzSearchDecode(f *testing.F) {
+	fuzzSetup(f)
+	for _, seed := range []string{
+		`{"target":"Brick","algorithm":"BFS","searchMode":"single"}`,
+		`{"target":"Brick","algorithm":"DFS","searchMode":"multiple","maxRecipes":3}`,
+		`{"target":"Wall","algorithm":"bidirectional","searchMode":"multiple","maxRecipes":-1}`,
+		`{"target":"Egg","searchMode":"kbest","maxRecipes":2,"rankBy":"steps","plan":true}`,
+		`{"target":"House","algorithm":"Cost","costs":{"default":"unit"},"output":"dag"}`,
+		`{"target":"Wall","algorithm":"BFS","searchMode":"multiple","maxRecipes":4,"inventory":["Brick"],"exclude":["Lava"],"require":["Mud"]}`,
+		`{"target":"Phoenix","algorithm":"DFS","searchMode":"single"}`,
+		`{"target":"","maxRecipes":9223372036854775807}`,
+		`{"target":["Brick"]}`,
+		`{}`,
+		`null`,
+		`[`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		serveSearch(t, body)
+	})
+}
+
+func FuzzSearchDispatch(f *testing.F) {
+	fuzzSetup(f)
+	f.Add("Brick", "BFS", "single", 0, "")
+	f.Add("Wall", "DFS", "multiple", 5, "")
+	f.Add("House", "bidirectional", "multiple", -3, "")
+	f.Add("Egg", "BFS", "kbest", 2, "steps")
+	f.Add("Chicken", "Cost", "multiple", 1<<40, "")
+	f.Add("Blackhole", "dijkstra", "all", 3, "elements")
+	f.Add("", "", "", 0, "")
+	f.Fuzz(func(t *testing.T, target, algorithm, searchMode string, maxRecipes int, rankBy string) {
+		body, err := json.Marshal(SearchRequest{
+			Target:     target,
+			Algorithm:  algorithm,
+			SearchMode: searchMode,
+			MaxRecipes: maxRecipes,
+			RankBy:     rankBy,
+		})
+		if err != nil {
+			t.Skip(fmt.Sprintf("not encodable: %v", err))
+		}
+		serveSearch(t, body)
+	})
+}
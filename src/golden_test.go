@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Rewrite the golden files after an intended change of results with:
+//
+//	go test -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite testdata/golden from the current results")
+
+// goldenDataset lists, per search variant, the targets whose result is
+// recorded.
+type goldenDataset struct {
+	name       string
+	file       string
+	maxRecipes int
+	targets    map[string][]string // variant name to targets
+}
+
+var goldenDatasets = []goldenDataset{
+	{
+		// hand-written: duplicate pairs (Steam), a self recipe (Stone), a cycle
+		// through Egg, Chicken and Life, and elements no search can make
+		name:       "fixture",
+		file:       "testdata/fixture.json",
+		maxRecipes: 50,
+		targets: map[string][]string{
+			"bfs_single":     {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+			"dfs_single":     {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+			"bidir_single":   {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+			"bfs_multiple":   {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+			"dfs_multiple":   {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+			"bidir_multiple": {"Mud", "Steam", "Lava", "Energy", "Stone", "Brick", "Wall", "House", "Life", "Egg", "Chicken"},
+		},
+	},
+	{
+		// frozen snapshot of recipes.json, which the server re-scrapes on start
+		name:       "recipes",
+		file:       "testdata/recipes.json.gz",
+		maxRecipes: 3,
+		targets: map[string][]string{
+			"bfs_single":     {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+			"dfs_single":     {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+			"bidir_single":   {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+			"bfs_multiple":   {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+			"dfs_multiple":   {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+			"bidir_multiple": {"Brick", "Stone", "Glass", "Steam", "Clay", "Sand", "Plant", "Human", "Smartphone"},
+		},
+	},
+}
+
+// goldenVariant runs one search, returning its trees and visited counts.
+// sharedCount variants report one count for all trees.
+type goldenVariant struct {
+	name        string
+	sharedCount bool
+	run         func(target string, maxRecipes int) ([]*TreeNode, []int)
+}
+
+var goldenVariants = []goldenVariant{
+	{"bfs_single", false, func(target string, _ int) ([]*TreeNode, []int) {
+		tree, visited := searchBFSOne(target, SearchOptions{})
+		return []*TreeNode{convertToTreeNode(tree.root)}, []int{visited}
+	}},
+	{"dfs_single", false, func(target string, _ int) ([]*TreeNode, []int) {
+		tree, visited := searchDFSOne(target, SearchOptions{})
+		if tree == nil {
+			return []*TreeNode{nil}, []int{visited}
+		}
+		return []*TreeNode{convertToTreeNode(tree.root)}, []int{visited}
+	}},
+	{"bidir_single", false, func(target string, _ int) ([]*TreeNode, []int) {
+		tree, visited := searchBidirectOne(target, SearchOptions{})
+		return []*TreeNode{convertToTreeNode2(tree)}, []int{visited}
+	}},
+	{"bfs_multiple", false, func(target string, maxRecipes int) ([]*TreeNode, []int) {
+		trees, visited := searchBFSMultiple(target, maxRecipes, SearchOptions{})
+		return convertTrees(trees), visited
+	}},
+	{"dfs_multiple", false, func(target string, maxRecipes int) ([]*TreeNode, []int) {
+		trees, visited := searchDFSMultiple(target, maxRecipes, SearchOptions{})
+		return convertTrees(trees), visited
+	}},
+	{"bidir_multiple", true, func(target string, maxRecipes int) ([]*TreeNode, []int) {
+		trees, visited := searchBidirectionMultiple(target, maxRecipes, SearchOptions{})
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNodes = append(treeNodes, convertToTreeNode2(tree))
+		}
+		return treeNodes, []int{visited}
+	}},
+}
+
+func convertTrees(trees []*Tree) []*TreeNode {
+	var treeNodes []*TreeNode
+	for _, tree := range trees {
+		treeNodes = append(treeNodes, convertToTreeNode(tree.root))
+	}
+	return treeNodes
+}
+
+// loadDataset replaces the loaded recipes like loadRecipes, reading gzipped
+// files too.
+func loadDataset(t testing.TB, filename string) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("read %s: %v", filename, err)
+	}
+	if strings.HasSuffix(filename, ".gz") {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("unzip %s: %v", filename, err)
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			t.Fatalf("unzip %s: %v", filename, err)
+		}
+	}
+	recipeData = OutputData{}
+	if err := json.Unmarshal(data, &recipeData); err != nil {
+		t.Fatalf("parse %s: %v", filename, err)
+	}
+	searchIndex = buildIndex(recipeData)
+	searchCache.clear()
+}
+
+// formatTree writes a tree on one line, e.g. "Brick(Mud(Water,Earth),Fire)",
+// keeping the children in the order the search returned them.
+func formatTree(node *TreeNode) string {
+	if node == nil {
+		return "<nil>"
+	}
+	if len(node.Children) == 0 {
+		return node.Name
+	}
+	children := make([]string, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = formatTree(child)
+	}
+	return node.Name + "(" + strings.Join(children, ",") + ")"
+}
+
+// formatResult writes one target's result, each tree with its visited count.
+// The trees are sorted, so only which trees were found matters, not their
+// order.
+func formatResult(target string, trees []*TreeNode, visited []int, sharedCount bool) string {
+	var lines []string
+	for i, tree := range trees {
+		count := visited[0]
+		if !sharedCount {
+			count = visited[i]
+		}
+		lines = append(lines, fmt.Sprintf("  %d %s", count, formatTree(tree)))
+	}
+	sort.Strings(lines)
+	return target + "\n" + strings.Join(lines, "\n") + "\n"
+}
+
+func TestGolden(t *testing.T) {
+	for _, dataset := range goldenDatasets {
+		loadDataset(t, dataset.file)
+		for _, variant := range goldenVariants {
+			t.Run(dataset.name+"/"+variant.name, func(t *testing.T) {
+				var got strings.Builder
+				for _, target := range dataset.targets[variant.name] {
+					trees, visited := variant.run(target, dataset.maxRecipes)
+					got.WriteString(formatResult(target, trees, visited, variant.sharedCount))
+				}
+
+				path := filepath.Join("testdata", "golden", dataset.name, variant.name+".golden")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, []byte(got.String()), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("read golden file, create it with -update: %v", err)
+				}
+				if got.String() != string(want) {
+					t.Errorf("results differ from %s\ngot:\n%s\nwant:\n%s", path, got.String(), want)
+				}
+			})
+		}
+	}
+}
+
+// TestFixtureDiagnostics covers the fixture elements no search can make.
+func TestFixtureDiagnostics(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+	cases := []struct {
+		target, code, element string
+	}{
+		{"Phoenix", errCyclicRecipes, "Phoenix"},
+		{"Blackhole", errNoRecipes, "Void"},
+		{"Void", errNoRecipes, "Void"},
+		{"Unobtainium", errUnknownElement, "Unobtainium"},
+	}
+	for _, c := range cases {
+		err := diagnoseTarget(c.target, SearchOptions{})
+		searchErr, ok := err.(*SearchError)
+		if !ok {
+			t.Errorf("%s: got %v, want a SearchError", c.target, err)
+			continue
+		}
+		if searchErr.Code != c.code || searchErr.Element != c.element {
+			t.Errorf("%s: got %s about %s, want %s about %s", c.target, searchErr.Code, searchErr.Element, c.code, c.element)
+		}
+	}
+}
+
+// TestKBestWarning checks that an approxElements ranking cut short at
+// kBestPool says so in the response.
+func TestKBestWarning(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+	cases := []struct {
+		target, rankBy string
+		approximate    bool
+	}{
+		{"Brick", "approxElements", false},
+		{"House", "approxElements", true},
+		{"House", "steps", false},
+		{"House", "", false},
+	}
+	for _, c := range cases {
+		req := SearchRequest{Target: c.target, SearchMode: "kbest", MaxRecipes: 3, RankBy: c.rankBy}
+		result := dispatchSearch(req, SearchOptions{})
+		warned := false
+		for _, warning := range searchWarnings(req, result) {
+			warned = warned || strings.Contains(warning, "approxElements")
+		}
+		if result.approximate != c.approximate || warned != c.approximate {
+			t.Errorf("%s by %s: approximate %v, warned %v, want %v", c.target, c.rankBy, result.approximate, warned, c.approximate)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+/*** PRECOMPUTED ELEMENT INDEX ***/
+
+// ElementInfo is what loadRecipes precomputes about one element, so a search
+// reads it instead of rebuilding it for every request.
+type ElementInfo struct {
+	Recipes  [][]string  // every recipe making the element
+	Pairs    [][2]string // the same recipes with a <= b, deduplicated and sorted
+	Uses     []Use       // recipes the element is an ingredient of
+	Closure  elementSet  // every element a tree for this one can contain
+	Tier     int         // minimum depth of a tree down to base elements, -1 if it can't be made
+	MinSize  int         // nodes in the smallest tree, 0 if it can't be made
+	Shortest []string    // root recipe of the smallest tree, the first in Pairs order on ties
+}
+
+type recipeIndex struct {
+	elements map[string]*ElementInfo
+	recipes  map[string][][]string // adjacency: element to the recipes making it
+	minSize  map[string]int        // MinSize of every element that has a recipe
+	version  string                // hash of the loaded dataset, see datasetVersion
+	names    []string              // every element, sorted case-insensitively; its position is its ID
+	ids      map[string]int        // element to its position in names
+	folded   map[string]string     // normalizeName of every element to the element
+}
+
+var searchIndex recipeIndex
+
+func buildIndex(data OutputData) recipeIndex {
+	idx := recipeIndex{
+		elements: make(map[string]*ElementInfo),
+		recipes:  make(map[string][][]string, len(data.Recipes)),
+		minSize:  make(map[string]int),
+		version:  datasetVersion(data),
+		folded:   make(map[string]string),
+		ids:      make(map[string]int),
+	}
+	info := func(element string) *ElementInfo {
+		if idx.elements[element] == nil {
+			idx.elements[element] = &ElementInfo{Tier: -1}
+		}
+		return idx.elements[element]
+	}
+	for _, element := range data.Elements {
+		info(element)
+	}
+	for element, recipeMap := range data.Recipes {
+		var recipes [][]string
+		for _, pair := range recipeMap[element] {
+			if len(pair) == 2 {
+				recipes = append(recipes, pair)
+			}
+		}
+		idx.recipes[element] = recipes
+		info(element).Recipes = recipes
+		info(element).Pairs = normalisePairs(recipes)
+	}
+
+	for element, uses := range buildUsesIndex(data) {
+		info(element).Uses = uses
+	}
+	for element, tier := range computeTiers(data) {
+		info(element).Tier = tier
+	}
+	idx.computeMinSize()
+
+	for element := range idx.elements {
+		idx.names = append(idx.names, element)
+		idx.folded[normalizeName(element)] = element
+	}
+	sort.Slice(idx.names, func(i, j int) bool {
+		a, b := strings.ToLower(idx.names[i]), strings.ToLower(idx.names[j])
+		return a < b || a == b && idx.names[i] < idx.names[j]
+	})
+	for id, element := range idx.names {
+		idx.ids[element] = id
+	}
+
+	for element, e := range idx.elements {
+		e.Closure = idx.collectClosure(element)
+	}
+	return idx
+}
+
+// elementSet holds element IDs as a bitset. A closure of every element takes
+// len(names)/8 bytes, where a map of recipes per element took an entry for
+// every element below it.
+type elementSet []uint64
+
+func newElementSet(size int) elementSet {
+	return make(elementSet, (size+63)/64)
+}
+
+func (s elementSet) add(id int) {
+	s[id/64] |= 1 << (id % 64)
+}
+
+func (s elementSet) has(id int) bool {
+	return s[id/64]&(1<<(id%64)) != 0
+}
+
+// collectClosure marks every element that can appear in a tree for element.
+func (idx recipeIndex) collectClosure(element string) elementSet {
+	closure := newElementSet(len(idx.names))
+	stack := []string{element}
+	closure.add(idx.ids[element])
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, pair := range idx.recipes[current] {
+			for _, ingredient := range pair {
+				if id := idx.ids[ingredient]; !closure.has(id) {
+					closure.add(id)
+					stack = append(stack, ingredient)
+				}
+			}
+		}
+	}
+	return closure
+}
+
+// closure returns the recipes of every element in target's closure. The
+// recipe slices are shared with idx.recipes, not copied.
+func (idx recipeIndex) closure(target string) map[string][][]string {
+	recipes := make(map[string][][]string)
+	for i, word := range idx.elements[target].Closure {
+		for ; word != 0; word &= word - 1 {
+			element := idx.names[i*64+bits.TrailingZeros64(word)]
+			if combs, ok := idx.recipes[element]; ok {
+				recipes[element] = combs
+			}
+		}
+	}
+	return recipes
+}
+
+// datasetVersion is the first 12 hex digits of the SHA-256 of the dataset as
+// JSON. Maps marshal with sorted keys, so equal datasets get equal versions.
+func datasetVersion(data OutputData) string {
+	encoded, _ := json.Marshal(data)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// normalisePairs orders both sides of every pair, so Water+Fire and Fire+Water
+// count once, and sorts the pairs by name.
+func normalisePairs(recipes [][]string) [][2]string {
+	seen := make(map[[2]string]bool)
+	var pairs [][2]string
+	for _, pair := range recipes {
+		if len(pair) != 2 {
+			continue
+		}
+		p := [2]string{pair[0], pair[1]}
+		if p[0] > p[1] {
+			p[0], p[1] = p[1], p[0]
+		}
+		if !seen[p] {
+			seen[p] = true
+			pairs = append(pairs, p)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	return pairs
+}
+
+// computeMinSize relaxes every recipe until the smallest tree sizes stop
+// changing, then records the recipe each smallest tree starts with.
+func (idx recipeIndex) computeMinSize() {
+	sizeOf := func(element string) (int, bool) {
+		if isBase(element) {
+			return 1, true
+		}
+		size, ok := idx.minSize[element]
+		return size, ok
+	}
+
+	changed := true
+	for changed {
+		changed = false
+		for element, e := range idx.elements {
+			for _, p := range e.Pairs {
+				s1, ok1 := sizeOf(p[0])
+				s2, ok2 := sizeOf(p[1])
+				if !ok1 || !ok2 {
+					continue
+				}
+				if cur, ok := idx.minSize[element]; !ok || 1+s1+s2 < cur {
+					idx.minSize[element] = 1 + s1 + s2
+					changed = true
+				}
+			}
+		}
+	}
+
+	for element, e := range idx.elements {
+		if isBase(element) {
+			e.MinSize = 1
+			continue
+		}
+		e.MinSize = idx.minSize[element]
+		for _, p := range e.Pairs {
+			s1, ok1 := sizeOf(p[0])
+			s2, ok2 := sizeOf(p[1])
+			if ok1 && ok2 && 1+s1+s2 == e.MinSize {
+				e.Shortest = []string{p[0], p[1]}
+				break
+			}
+		}
+	}
+}
+
+// lookupElement returns the index entry of element, nil if it isn't in the
+// dataset.
+func lookupElement(element string) *ElementInfo {
+	return searchIndex.elements[element]
+}
+
+// shortestFirst puts the recipe of element that roots its smallest tree in
+// front of recipes, so a search that takes the first recipe it can make tries
+// the canonical shortest one first. recipes is returned as is when that recipe
+// isn't among them, e.g. because the inventory or an exclusion filtered it.
+func shortestFirst(element string, recipes [][]string) [][]string {
+	info := lookupElement(element)
+	if info == nil || info.Shortest == nil {
+		return recipes
+	}
+	for i, pair := range recipes {
+		if findRecipe([][]string{pair}, info.Shortest[0], info.Shortest[1]) == nil {
+			continue
+		}
+		if i == 0 {
+			return recipes
+		}
+		ordered := make([][]string, 0, len(recipes))
+		ordered = append(ordered, pair)
+		ordered = append(ordered, recipes[:i]...)
+		return append(ordered, recipes[i+1:]...)
+	}
+	return recipes
+}
+
+// canonicalName finds the element whose name matches name ignoring case and
+// extra whitespace.
+func canonicalName(name string) (string, bool) {
+	if _, ok := searchIndex.elements[name]; ok {
+		return name, true
+	}
+	element, ok := searchIndex.folded[normalizeName(name)]
+	return element, ok
+}
+
+func tierOf(element string) int {
+	if e := lookupElement(element); e != nil && e.Tier > 0 {
+		return e.Tier
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// deepTargets have the biggest smallest trees in recipes.json.
+var deepTargets = []string{"Smartphone", "Barrel", "Librarian"}
+
+// baselineTargets are the deepest targets whose tree of every recipe still
+// fits in memory: for deepTargets it has more than five million nodes.
+var baselineTargets = []string{"Meat", "Egg", "Knife"}
+
+var loadBenchmarkRecipes sync.Once
+
+func loadIndex(b *testing.B) {
+	b.Helper()
+	loadBenchmarkRecipes.Do(func() { loadRecipes("recipes.json") })
+}
+
+// baselineRecipeTree builds what bidirectional search built for every request
+// before the index: the tree of every recipe below target, with an element
+// once per path to it and cut where it would be its own ancestor. It returns
+// the number of nodes.
+func baselineRecipeTree(target string) int {
+	recipes := recipeData.Recipes[target]
+	isAncestor := func(node *Nodebidir, element string) bool {
+		for curr := node.parent; curr != nil; curr = curr.parent {
+			if curr.element == element {
+				return true
+			}
+		}
+		return false
+	}
+
+	count := 1
+	queue := []*Nodebidir{{element: target}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if isBase(current.element) {
+			continue
+		}
+		for _, pair := range recipes[current.element] {
+			if len(pair) != 2 {
+				continue
+			}
+			recipe := Recipebidir{}
+			for i, ingredient := range pair {
+				node := &Nodebidir{element: ingredient, parent: current}
+				if !isAncestor(current, ingredient) && !isBase(ingredient) {
+					queue = append(queue, node)
+				}
+				if i == 0 {
+					recipe.ingredient1 = node
+				} else {
+					recipe.ingredient2 = node
+				}
+				count++
+			}
+			current.combinations = append(current.combinations, recipe)
+		}
+	}
+	return count
+}
+
+// baselineEnumerator sets up an enumerator the way every request did before
+// the index: normalising the pairs of the target's recipes and relaxing the
+// smallest tree sizes again.
+func baselineEnumerator(target string) *treeEnumerator {
+	e := &treeEnumerator{
+		target:  target,
+		recipes: make(map[string][][2]string),
+		minSize: make(map[string]int),
+		counts:  make(map[enumKey]uint64),
+	}
+	for element, combs := range recipeData.Recipes[target] {
+		e.recipes[element] = normalisePairs(combs)
+	}
+	e.computeMinSize()
+	return e
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	loadIndex(b)
+	for i := 0; i < b.N; i++ {
+		buildIndex(recipeData)
+	}
+}
+
+// BenchmarkRecipesFor collects the recipe closure a search walks, which the
+// index no longer stores per element.
+func BenchmarkRecipesFor(b *testing.B) {
+	loadIndex(b)
+	for _, target := range deepTargets {
+		b.Run(target, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				SearchOptions{}.recipesFor(target)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchDeep runs each algorithm on the index.
+func BenchmarkSearchDeep(b *testing.B) {
+	loadIndex(b)
+	algorithms := []struct {
+		name   string
+		search func(target string)
+	}{
+		{"BFS", func(target string) { searchBFSOne(target, SearchOptions{}) }},
+		{"DFS", func(target string) { searchDFSOne(target, SearchOptions{}) }},
+		{"Bidirectional", func(target string) { searchBidirectOne(target, SearchOptions{}) }},
+		{"Cost", func(target string) { searchCostOne(target, SearchOptions{}, (*CostWeights)(nil).costFunc()) }},
+		{"Enumerator", func(target string) { newTreeEnumerator(target, SearchOptions{}) }},
+		{"KBest", func(target string) { searchKBest(target, 5, "approxElements", SearchOptions{}) }},
+	}
+	for _, algorithm := range algorithms {
+		for _, target := range deepTargets {
+			b.Run(algorithm.name+"/"+target, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					algorithm.search(target)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSearchBaseline compares the searches that rebuilt a structure for
+// every request before the index with that rebuild alone. BFS, DFS and Cost
+// read the scraped recipes directly then as now, so they have no baseline.
+func BenchmarkSearchBaseline(b *testing.B) {
+	loadIndex(b)
+	algorithms := []struct {
+		name     string
+		indexed  func(target string)
+		baseline func(target string)
+	}{
+		{"Bidirectional",
+			func(target string) { searchBidirectOne(target, SearchOptions{}) },
+			func(target string) { baselineRecipeTree(target) }},
+		{"Enumerator",
+			func(target string) { newTreeEnumerator(target, SearchOptions{}) },
+			func(target string) { baselineEnumerator(target) }},
+	}
+	for _, algorithm := range algorithms {
+		for _, target := range baselineTargets {
+			b.Run(algorithm.name+"/"+target+"/indexed", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					algorithm.indexed(target)
+				}
+			})
+			b.Run(algorithm.name+"/"+target+"/baseline", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					algorithm.baseline(target)
+				}
+			})
+		}
+	}
+}
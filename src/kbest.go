@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+)
+
+/*** K-BEST RECIPE TREES ***/
+
+// kBestPool is how many canonical trees rankBy approxElements ranks. Trees
+// come out smallest first, so ranking by steps only needs the first K and is
+// exact. A bigger tree can reuse more elements and still use fewer distinct
+// ones, and no bound on the distinct elements of the trees left in the listing
+// is close enough to the best scores to stop early, so ranking by elements
+// only samples the start of the listing and is approximate by name. Ranking
+// 2000 trees takes about a quarter of a second for the deepest targets.
+const kBestPool = 2000
+
+// countSteps counts the combinations a tree performs, repeats included.
+func countSteps(node *Node) int {
+	if node == nil || len(node.combinations) == 0 {
+		return 0
+	}
+	recipe := node.combinations[0]
+	return 1 + countSteps(recipe.ingredient1) + countSteps(recipe.ingredient2)
+}
+
+// searchKBest returns the k trees of target with the lowest score in ascending
+// order, together with their getPathElementCount and their score. rankBy is
+// "steps" (combinations, the default) or "approxElements" (distinct elements,
+// as in nodesVisited, among the first kBestPool trees). approximate is set when
+// approxElements left trees beyond kBestPool unranked.
+func searchKBest(target string, k int, rankBy string, opts SearchOptions) (trees []*Tree, pathElementCounts []int, scores []int, approximate bool) {
+	enumerator := newTreeEnumerator(target, opts)
+
+	byElements := rankBy == "approxElements"
+	poolSize := k
+	if byElements {
+		poolSize = kBestPool
+	}
+
+	type rankedTree struct {
+		root            *Node
+		elements, score int
+	}
+	var pool []rankedTree
+	var cursor uint64
+	more := true
+	for more && len(pool) < poolSize && !opts.cancelled() {
+		page := enumerator.page(cursor, min(poolSize-len(pool), maxPageSize))
+		cursor, more = page.next, page.more
+		for _, root := range page.roots {
+			ranked := rankedTree{root: root, elements: getPathElementCount(root), score: countSteps(root)}
+			if byElements {
+				ranked.score = ranked.elements
+			}
+			pool = append(pool, ranked)
+		}
+		if len(page.roots) == 0 {
+			// a whole page of cyclic candidates, the rest of the listing is
+			// most likely more of them
+			break
+		}
+	}
+
+	// stable, so equal scores keep the canonical (smallest first) order
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].score < pool[j].score
+	})
+	if len(pool) > k {
+		pool = pool[:k]
+	}
+
+	for _, ranked := range pool {
+		trees = append(trees, &Tree{root: ranked.root})
+		pathElementCounts = append(pathElementCounts, ranked.elements)
+		scores = append(scores, ranked.score)
+	}
+	return trees, pathElementCounts, scores, more && byElements
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,10 +10,19 @@ import (
 
 // SearchRequest adalah struktur input API
 type SearchRequest struct {
-	Target     string `json:"target"`
-	Algorithm  string `json:"algorithm"`
-	SearchMode string `json:"searchMode"`
-	MaxRecipes int    `json:"maxRecipes"`
+	Target     string       `json:"target"`
+	Algorithm  string       `json:"algorithm"`
+	SearchMode string       `json:"searchMode"`
+	MaxRecipes int          `json:"maxRecipes"`
+	Inventory  []string     `json:"inventory"`
+	Exclude    []string     `json:"exclude"`
+	Require    []string     `json:"require"`
+	Costs      *CostWeights `json:"costs"`
+	RankBy     string       `json:"rankBy"` // k-best mode: "steps" or "approxElements"
+	Output     string       `json:"output"` // "tree" (default) or "dag", see RecipeDAG
+	Plan       bool         `json:"plan"`   // also return ordered crafting steps, see PlanStep
+
+	ResponseVersion int `json:"responseVersion"` // 1 for the legacy shapes, see SearchResponseV2
 }
 
 type TreeNode struct {
@@ -23,15 +31,35 @@ type TreeNode struct {
 }
 
 type SearchResponse struct {
-	Trees         []*TreeNode `json:"tree"`
-	NodesVisited  []int       `json:"nodesVisited"`
-	ExecutionTime float64     `json:"executionTime"`
+	Trees           []*TreeNode  `json:"tree"`
+	NodesVisited    []int        `json:"nodesVisited"`
+	NewCombinations []int        `json:"newCombinations"`
+	Costs           []float64    `json:"costs,omitempty"`
+	DAGs            []*RecipeDAG `json:"dags,omitempty"`
+	Plans           [][]PlanStep `json:"plan,omitempty"`
+	Cache           string       `json:"cache"` // "hit" when served from the result cache, else "miss"
+	ExecutionTime   float64      `json:"executionTime"`
 }
 
 type MultipleSearchResponse struct {
-	Trees         []*TreeNode `json:"trees"`
-	NodesVisited  []int       `json:"nodesVisited"`
-	ExecutionTime float64     `json:"executionTime"`
+	Trees           []*TreeNode  `json:"trees"`
+	NodesVisited    []int        `json:"nodesVisited"`
+	NewCombinations []int        `json:"newCombinations"`
+	Scores          []int        `json:"scores,omitempty"`
+	DAGs            []*RecipeDAG `json:"dags,omitempty"`
+	Plans           [][]PlanStep `json:"plan,omitempty"`
+	Cache           string       `json:"cache"`
+	ExecutionTime   float64      `json:"executionTime"`
+}
+
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Code    string `json:"code,omitempty"`    // why a search failed, see SearchError
+	Element string `json:"element,omitempty"` // the element the failure is about
+
+	Suggestions []string `json:"suggestions,omitempty"` // unknown_element: names the element may be a typo of
+
+	Fields []FieldError `json:"fields,omitempty"` // invalid_request: every field not matching openapi.json
 }
 
 // Store Recipe Data
@@ -45,6 +73,8 @@ func loadRecipes(filename string) {
 	if err := json.Unmarshal(data, &recipeData); err != nil {
 		log.Fatalf("failed to parse %s: %v", filename, err)
 	}
+	searchIndex = buildIndex(recipeData)
+	searchCache.clear()
 	log.Printf("Loaded %d elements and %d recipes from %s\n",
 		len(recipeData.Elements), len(recipeData.Recipes), filename)
 }
@@ -99,13 +129,273 @@ func convertToTreeNode2(n *Nodebidir) *TreeNode {
 	return node
 }
 
-func searchHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received search request")
-
+func setCORSHeaders(w http.ResponseWriter, methods string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp interface{}) {
+	respData, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
+		log.Printf("Failed to marshal response: %v\n", err)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(respData)
+}
+
+// searchResult is what one dispatch found, before it is shaped into a response
+type searchResult struct {
+	kind         int
+	trees        []*TreeNode
+	nodesVisited []int
+	costs        []float64 // total cost per tree, cost search only
+	scores       []int     // ranking score per tree, k-best only
+	dag          bool      // answer with DAGs instead of nested trees
+	plan         bool      // add a crafting plan per tree
+	cached       bool      // served from searchCache
+	want         int       // number of trees the request asked for
+	approximate  bool      // k-best: only the first kBestPool trees were ranked
+	unfinished   []string  // where each tree dropIncomplete dropped stopped, see incompleteLeaf
+}
+
+// keepTrees returns the result with only the trees keep accepts, together with
+// their per-tree stats.
+func (r searchResult) keepTrees(keep func(tree *TreeNode) bool) searchResult {
+	kept := r
+	kept.trees, kept.costs, kept.scores = nil, nil, nil
+	if r.kind != bidirMultipleResult {
+		kept.nodesVisited = nil
+	}
+	for i, tree := range r.trees {
+		if !keep(tree) {
+			continue
+		}
+		kept.trees = append(kept.trees, tree)
+		if r.kind != bidirMultipleResult {
+			kept.nodesVisited = append(kept.nodesVisited, r.nodesVisited[i])
+		}
+		if r.costs != nil {
+			kept.costs = append(kept.costs, r.costs[i])
+		}
+		if r.scores != nil {
+			kept.scores = append(kept.scores, r.scores[i])
+		}
+	}
+	return kept
+}
+
+const (
+	singleResult        = iota // SearchResponse
+	multipleResult             // MultipleSearchResponse
+	bidirMultipleResult        // multiple bidirectional, one total visited count
+)
+
+func dispatchSearch(req SearchRequest, opts SearchOptions) searchResult {
+	target := req.Target
+	maxRecipes := req.MaxRecipes
+
+	if req.Algorithm == "Cost" {
+		// only the cheapest tree, see costModeError
+		tree, node, cost := searchCostOne(target, opts, req.Costs.costFunc())
+		var treeNode *TreeNode
+		if tree != nil {
+			treeNode = convertToTreeNode(tree.root)
+		}
+		return searchResult{kind: singleResult, trees: []*TreeNode{treeNode}, nodesVisited: []int{node}, costs: []float64{cost}, want: 1}
+	}
+
+	if req.SearchMode == "kbest" {
+		k := max(maxRecipes, 1)
+		trees, nodeVisited, scores, approximate := searchKBest(target, k, req.RankBy, opts)
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNodes = append(treeNodes, convertToTreeNode(tree.root))
+		}
+		return searchResult{kind: multipleResult, trees: treeNodes, nodesVisited: nodeVisited, scores: scores, want: k, approximate: approximate}
+	}
+
+	if req.SearchMode == "single" {
+		return searchSingle(target, req.Algorithm, opts)
+	}
+
+	// multiple
+	if req.Algorithm == "DFS" || req.Algorithm == "BFS" {
+		if maxRecipes <= 1 {
+			return searchSingle(target, req.Algorithm, opts)
+		}
+
+		var trees []*Tree
+		var nodeVisited []int
+		if req.Algorithm == "DFS" {
+			trees, nodeVisited = searchDFSMultiple(target, maxRecipes, opts)
+		} else {
+			trees, nodeVisited = searchBFSMultiple(target, maxRecipes, opts) //changed to check
+		}
+		var treeNodes []*TreeNode
+		for _, tree := range trees {
+			treeNode := convertToTreeNode(tree.root)
+			treeNodes = append(treeNodes, treeNode)
+		}
+		return searchResult{kind: multipleResult, trees: treeNodes, nodesVisited: nodeVisited, want: maxRecipes}
+	}
+
+	if maxRecipes <= 0 {
+		maxRecipes = 1 // Default value
+	}
+	trees, node := searchBidirectionMultiple(target, maxRecipes, opts)
+	var treeNodes []*TreeNode
+	for _, tree := range trees {
+		treeNode := convertToTreeNode2(tree)
+		treeNodes = append(treeNodes, treeNode)
+	}
+	return searchResult{kind: bidirMultipleResult, trees: treeNodes, nodesVisited: []int{node}, want: maxRecipes}
+}
+
+func searchSingle(target string, algorithm string, opts SearchOptions) searchResult {
+	var treeNode *TreeNode
+	var node int
+	if algorithm == "DFS" {
+		var tree *Tree
+		if tree, node = searchDFSOne(target, opts); tree != nil {
+			treeNode = convertToTreeNode(tree.root)
+		}
+	} else if algorithm == "BFS" {
+		var tree *Tree
+		tree, node = searchBFSOne(target, opts)
+		treeNode = convertToTreeNode(tree.root)
+	} else {
+		var tree *Nodebidir
+		tree, node = searchBidirectOne(target, opts)
+		treeNode = convertToTreeNode2(tree)
+	}
+	return searchResult{kind: singleResult, trees: []*TreeNode{treeNode}, nodesVisited: []int{node}, want: 1}
+}
+
+// response shapes r for req, as a SearchResponseV2 unless req or
+// SEARCH_RESPONSE_VERSION asks for the legacy shapes.
+func (r searchResult) response(req SearchRequest, executionTime float64) interface{} {
+	version := req.ResponseVersion
+	if version == 0 {
+		version = defaultResponseVersion
+	}
+	if version == legacyResponseVersion {
+		return r.legacyResponse(executionTime)
+	}
+	return r.responseV2(req, executionTime)
+}
+
+func (r searchResult) legacyResponse(executionTime float64) interface{} {
+	trees := r.trees
+	var dags []*RecipeDAG
+	if r.dag {
+		dags = buildRecipeDAGs(r.trees)
+		trees = []*TreeNode{}
+	}
+	var plans [][]PlanStep
+	if r.plan {
+		plans = buildPlans(r.trees)
+	}
+	cache := "miss"
+	if r.cached {
+		cache = "hit"
+	}
+
+	switch r.kind {
+	case multipleResult:
+		return MultipleSearchResponse{
+			Trees:           trees,
+			ExecutionTime:   executionTime,
+			NodesVisited:    r.nodesVisited,
+			NewCombinations: countNewCombinations(r.trees),
+			Scores:          r.scores,
+			DAGs:            dags,
+			Plans:           plans,
+			Cache:           cache,
+		}
+	case bidirMultipleResult:
+		// Define a new response structure for multiple trees
+		type MultipleSearchResponse struct {
+			Trees           []*TreeNode  `json:"trees"`
+			NodesVisited    int          `json:"nodesVisited"`
+			NewCombinations []int        `json:"newCombinations"`
+			DAGs            []*RecipeDAG `json:"dags,omitempty"`
+			Plans           [][]PlanStep `json:"plan,omitempty"`
+			Cache           string       `json:"cache"`
+			ExecutionTime   float64      `json:"executionTime"`
+		}
+
+		return MultipleSearchResponse{
+			Trees:           trees,
+			ExecutionTime:   executionTime,
+			NodesVisited:    r.nodesVisited[0],
+			NewCombinations: countNewCombinations(r.trees),
+			DAGs:            dags,
+			Plans:           plans,
+			Cache:           cache,
+		}
+	default:
+		return SearchResponse{
+			Trees:           trees,
+			ExecutionTime:   executionTime,
+			NodesVisited:    r.nodesVisited,
+			NewCombinations: countNewCombinations(r.trees),
+			Costs:           r.costs,
+			DAGs:            dags,
+			Plans:           plans,
+			Cache:           cache,
+		}
+	}
+}
+
+// runSearch answers a request from searchCache, or searches and caches the
+// result. Failures are *SearchError values saying why there is no tree.
+func runSearch(req SearchRequest, opts SearchOptions) (searchResult, error) {
+	req.Target = resolveName(req.Target)
+	if err := diagnoseTarget(req.Target, opts); err != nil {
+		return searchResult{}, err
+	}
+
+	// a streamed search is run again, the point is to watch it explore
+	cacheKey := searchCacheKey(req)
+	if result, ok := searchCache.get(cacheKey); ok && opts.events == nil {
+		result.cached = true
+		return result, nil
+	}
+
+	result, err := dispatchWithTimeout(req, opts)
+	if err != nil {
+		return result, err
+	}
+	if result, err = dropIncomplete(req.Target, result, opts); err != nil {
+		return result, err
+	}
+	result = dropDuplicates(result)
+	if debugMode {
+		if err := verifyResult(req.Target, result, opts); err != nil {
+			return result, err
+		}
+	}
+	if len(opts.Require) > 0 {
+		if result, err = applyRequire(req.Target, result, opts); err != nil {
+			return result, &SearchError{Code: errUnsatisfiable, Element: req.Target, Message: err.Error()}
+		}
+	}
+	// with a memo shared across a batch the visited counts depend on what the
+	// batch searched before, so only standalone results are cached
+	if opts.memo == nil {
+		searchCache.put(cacheKey, result)
+	}
+	return result, nil
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received search request")
+
+	setCORSHeaders(w, "POST, OPTIONS")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -124,133 +414,30 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to decode request: %v\n", err)
 		return
 	}
+	req.spellSettings()
 
 	log.Printf("Searching for target: '%s' using algorithm: %s, mode: %s, maxRecipes: %d\n",
 		req.Target, req.Algorithm, req.SearchMode, req.MaxRecipes)
 
-	target := req.Target
-	fmt.Printf("Target: %s\n", target)
+	if err := costModeError(req); err != nil {
+		writeSearchError(w, err)
+		log.Printf("Invalid request: %v\n", err)
+		return
+	}
 
 	startTime := time.Now()
 
-	var resp interface{}
-	if req.SearchMode == "single" {
-		var node int
-		var tree *Tree
-		if req.Algorithm == "DFS" {
-			tree, node = searchDFSOne(target)
-			treeNode := convertToTreeNode(tree.root)
-			executionTime := time.Since(startTime).Milliseconds()
-			resp = SearchResponse{
-				Trees:         []*TreeNode{treeNode},
-				ExecutionTime: float64(executionTime),
-				NodesVisited:  []int{node},
-			}
-
-		} else if req.Algorithm == "BFS" {
-			tree, node := searchBFSOne(target)
-			treeNode := convertToTreeNode(tree.root)
-			executionTime := time.Since(startTime).Milliseconds()
-			resp = SearchResponse{
-				Trees:         []*TreeNode{treeNode},
-				ExecutionTime: float64(executionTime),
-				NodesVisited:  []int{node},
-			}
-
-		} else {
-			tree, node := searchBidirectOne(target)
-			treeNode := convertToTreeNode2(tree)
-			executionTime := time.Since(startTime).Milliseconds()
-			resp = SearchResponse{
-				Trees:         []*TreeNode{treeNode},
-				ExecutionTime: float64(executionTime),
-				NodesVisited:  []int{node},
-			}
-		}
-	} else { // multiple
-		var trees []*Tree
-		var nodeVisited []int
-		if req.Algorithm == "DFS" {
-			maxRecipes := req.MaxRecipes
-			if maxRecipes <= 1 {
-				var node int
-				var tree *Tree
-				tree, node = searchDFSOne(target)
-				treeNode := convertToTreeNode(tree.root)
-				executionTime := time.Since(startTime).Milliseconds()
-				resp = SearchResponse{
-					Trees:         []*TreeNode{treeNode},
-					ExecutionTime: float64(executionTime),
-					NodesVisited:  []int{node},
-				}
-			} else {
-				trees, nodeVisited = searchDFSMultiple(target, maxRecipes)
-				var treeNodes []*TreeNode
-				for _, tree := range trees {
-					treeNode := convertToTreeNode(tree.root)
-					treeNodes = append(treeNodes, treeNode)
-				}
-
-				executionTime := time.Since(startTime).Milliseconds()
-				resp = MultipleSearchResponse{
-					Trees:         treeNodes,
-					ExecutionTime: float64(executionTime),
-					NodesVisited:  nodeVisited,
-				}
-			}
-		} else if req.Algorithm == "BFS" {
-			maxRecipes := req.MaxRecipes
-			if maxRecipes <= 1 {
-				tree, node := searchBFSOne(target)
-				treeNode := convertToTreeNode(tree.root)
-				executionTime := time.Since(startTime).Milliseconds()
-				resp = SearchResponse{
-					Trees:         []*TreeNode{treeNode},
-					ExecutionTime: float64(executionTime),
-					NodesVisited:  []int{node},
-				}
-			} else {
-				trees, nodeVisited := searchBFSMultiple(target, maxRecipes) //changed to check
-				var treeNodes []*TreeNode
-				for _, tree := range trees {
-					treeNode := convertToTreeNode(tree.root)
-					treeNodes = append(treeNodes, treeNode)
-				}
-				executionTime := time.Since(startTime).Milliseconds()
-				resp = MultipleSearchResponse{
-					Trees:         treeNodes,
-					ExecutionTime: float64(executionTime),
-					NodesVisited:  nodeVisited,
-				}
-			}
-		} else {
-			maxRecipes := req.MaxRecipes
-			if maxRecipes <= 0 {
-				maxRecipes = 1 // Default value
-			}
-			trees, node := searchBidirectionMultiple(target, maxRecipes)
-			var treeNodes []*TreeNode
-			for _, tree := range trees {
-				treeNode := convertToTreeNode2(tree)
-				treeNodes = append(treeNodes, treeNode)
-			}
-
-			executionTime := time.Since(startTime).Milliseconds()
-
-			// Define a new response structure for multiple trees
-			type MultipleSearchResponse struct {
-				Trees         []*TreeNode `json:"trees"`
-				NodesVisited  int         `json:"nodesVisited"`
-				ExecutionTime float64     `json:"executionTime"`
-			}
-
-			resp = MultipleSearchResponse{
-				Trees:         treeNodes,
-				ExecutionTime: float64(executionTime),
-				NodesVisited:  node,
-			}
-		}
+	result, err := runSearch(req, newSearchOptions(req))
+	if err != nil {
+		writeSearchError(w, err)
+		log.Printf("Search failed: %v\n", err)
+		return
 	}
+
+	result.dag = req.Output == "dag"
+	result.plan = req.Plan
+	executionTime := time.Since(startTime).Milliseconds()
+	resp := result.response(req, float64(executionTime))
 	respData, err := json.Marshal(resp)
 	if err != nil {
 		http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
@@ -264,6 +451,8 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	debugMode = os.Getenv("SEARCH_DEBUG") != ""
+
 	// First scrape the recipes
 	var err error
 	recipeData, err = ScrapeRecipes()
@@ -277,9 +466,23 @@ func main() {
 		log.Fatalf("Error saving recipes to JSON: %v", err)
 	}
 
+	searchCache = newResultCacheFromEnv()
+	defaultResponseVersion = responseVersionFromEnv()
 	loadRecipes("recipes.json")
 
-	http.HandleFunc("/api/search", searchHandler)
+	handleAPI("/api/search", searchHandler)
+	handleAPI("/api/search/stream", searchStreamHandler)
+	handleAPI("/api/enumerate", enumerateHandler)
+	handleAPI("/api/uses/{element}", usesHandler)
+	handleAPI("/api/reachable", reachableHandler)
+	handleAPI("/api/closure", closureHandler)
+	handleAPI("/api/batch", batchHandler)
+	handleAPI("/api/combined", combinedHandler)
+	handleAPI("/api/verify", verifyHandler)
+	handleAPI("/api/elements", elementsHandler)
+	handleAPI("/api/elements/{name}", elementHandler)
+	handleAPI("/api/autocomplete", autocompleteHandler)
+	handleAPI("/api/openapi.json", openAPIHandler)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*** OPENAPI DOCUMENT AND REQUEST VALIDATION ***/
+
+// openAPIDocument describes every endpoint, served as is at /api/openapi.json.
+// Requests are checked against it by validated, so a new field or limit only
+// needs to be written down once, here.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+var openAPISpec, openAPIPatterns = loadOpenAPISpec(openAPIDocument)
+
+// errInvalidRequest is the code of a request that does not match the document.
+const errInvalidRequest = "invalid_request"
+
+// FieldError is one field of a request that does not match the document.
+// Field is a path into the JSON body like "costs.elements.Fire" or
+// "targets[2]", or the name of a query or path parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func loadOpenAPISpec(document []byte) (map[string]interface{}, map[string]*regexp.Regexp) {
+	spec, patterns, err := parseOpenAPISpec(document)
+	if err != nil {
+		log.Fatalf("failed to parse openapi.json: %v", err)
+	}
+	return spec, patterns
+}
+
+// parseOpenAPISpec parses document and compiles every pattern in it, keyed
+// by the pattern, so validating a string never compiles one.
+func parseOpenAPISpec(document []byte) (map[string]interface{}, map[string]*regexp.Regexp, error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(document, &spec); err != nil {
+		return nil, nil, err
+	}
+	patterns := make(map[string]*regexp.Regexp)
+	var walk func(node interface{}) error
+	walk = func(node interface{}) error {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if pattern, ok := node["pattern"].(string); ok && patterns[pattern] == nil {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("pattern %s: %v", pattern, err)
+				}
+				patterns[pattern] = re
+			}
+			for _, child := range node {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range node {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(spec); err != nil {
+		return nil, nil, err
+	}
+	return spec, patterns, nil
+}
+
+// openAPIHandler serves GET /api/openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// handleAPI registers handler for route behind validated.
+func handleAPI(route string, handler http.HandlerFunc) {
+	http.HandleFunc(route, validated(route, handler))
+}
+
+// validated checks the parameters and JSON body of requests to route, a path
+// of the OpenAPI document, before handler sees them. Requests that don't match
+// get a 400 listing every wrong field. Methods the document has no operation
+// for, OPTIONS among them, are left to handler.
+func validated(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operation := specOperation(route, r.Method)
+		if operation == nil {
+			handler(w, r)
+			return
+		}
+
+		setCORSHeaders(w, r.Method+", OPTIONS")
+		v := &schemaValidator{spec: openAPISpec, patterns: openAPIPatterns}
+		v.checkParameters(operation, r)
+		if body, ok := operation["requestBody"].(map[string]interface{}); ok {
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "failed to read request body", Code: errInvalidRequest})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			if err := v.checkBody(body, data); err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid JSON: " + err.Error(), Code: errInvalidRequest})
+				return
+			}
+		}
+		if len(v.errors) > 0 {
+			messages := make([]string, len(v.errors))
+			for i, e := range v.errors {
+				messages[i] = e.Field + " " + e.Message
+			}
+			log.Printf("Invalid request to %s: %s\n", route, strings.Join(messages, "; "))
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:  "invalid request: " + strings.Join(messages, "; "),
+				Code:   errInvalidRequest,
+				Fields: v.errors,
+			})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// specOperation finds the operation of the document for route and method.
+func specOperation(route, method string) map[string]interface{} {
+	paths, _ := openAPISpec["paths"].(map[string]interface{})
+	item, _ := paths[route].(map[string]interface{})
+	operation, _ := item[strings.ToLower(method)].(map[string]interface{})
+	return operation
+}
+
+// schemaValidator checks values against the schemas of the document. It knows
+// the keywords the document uses: $ref, allOf, type, nullable, enum, minimum,
+// maximum, minLength, pattern, minItems, maxItems, items, properties, required
+// and additionalProperties.
+type schemaValidator struct {
+	spec     map[string]interface{}
+	patterns map[string]*regexp.Regexp // every pattern of spec, compiled
+	errors   []FieldError
+}
+
+// fail records a wrong field once, though every part of an allOf may find it.
+func (v *schemaValidator) fail(field, format string, args ...interface{}) {
+	e := FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+	for _, seen := range v.errors {
+		if seen == e {
+			return
+		}
+	}
+	v.errors = append(v.errors, e)
+}
+
+// resolve follows a $ref like "#/components/schemas/TreeNode".
+func (v *schemaValidator) resolve(schema map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		var node interface{} = v.spec
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			object, _ := node.(map[string]interface{})
+			node = object[part]
+		}
+		if schema, ok = node.(map[string]interface{}); !ok {
+			log.Fatalf("openapi.json: broken reference %s", ref)
+		}
+	}
+}
+
+// checkParameters checks the query and path parameters of r. Empty query
+// values count as missing, like the handlers treat them.
+func (v *schemaValidator) checkParameters(operation map[string]interface{}, r *http.Request) {
+	parameters, _ := operation["parameters"].([]interface{})
+	for _, p := range parameters {
+		parameter, _ := p.(map[string]interface{})
+		parameter = v.resolve(parameter)
+		name, _ := parameter["name"].(string)
+		var raw string
+		switch parameter["in"] {
+		case "query":
+			raw = r.URL.Query().Get(name)
+		case "path":
+			raw = r.PathValue(name)
+		}
+		if raw == "" {
+			if required, _ := parameter["required"].(bool); required {
+				v.fail(name, "is required")
+			}
+			continue
+		}
+
+		schema, _ := parameter["schema"].(map[string]interface{})
+		schema = v.resolve(schema)
+		var value interface{} = raw
+		if schema["type"] == "integer" || schema["type"] == "number" {
+			value = json.Number(raw)
+		}
+		v.check(name, value, schema)
+	}
+}
+
+// checkBody checks a JSON request body. It only returns an error when the
+// body is not JSON at all, wrong fields are collected in v.errors.
+func (v *schemaValidator) checkBody(requestBody map[string]interface{}, data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			v.fail("body", "is required")
+		}
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	content, _ := requestBody["content"].(map[string]interface{})
+	mediaType, _ := content["application/json"].(map[string]interface{})
+	schema, _ := mediaType["schema"].(map[string]interface{})
+	v.check("", value, schema)
+	return nil
+}
+
+// check checks value, decoded with UseNumber, against schema. field is where
+// value sits in the body, "" for the body itself.
+func (v *schemaValidator) check(field string, value interface{}, schema map[string]interface{}) {
+	schema = v.resolve(schema)
+	name := field
+	if name == "" {
+		name = "body"
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			subSchema, _ := sub.(map[string]interface{})
+			v.check(field, value, subSchema)
+		}
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			v.fail(name, "must not be null")
+		}
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(name, "must be an object")
+			return
+		}
+		v.checkObject(field, object, schema)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.fail(name, "must be an array")
+			return
+		}
+		if minItems, ok := schema["minItems"].(float64); ok && len(array) < int(minItems) {
+			if minItems == 1 {
+				v.fail(name, "must not be empty")
+			} else {
+				v.fail(name, "must have at least %d items", int(minItems))
+			}
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && len(array) > int(maxItems) {
+			v.fail(name, "must have at most %d items", int(maxItems))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range array {
+				v.check(fmt.Sprintf("%s[%d]", field, i), item, items)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(name, "must be a string")
+			return
+		}
+		if minLength, ok := schema["minLength"].(float64); ok && utf8.RuneCountInString(s) < int(minLength) {
+			if minLength == 1 {
+				v.fail(name, "must not be empty")
+			} else {
+				v.fail(name, "must be at least %d characters", int(minLength))
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && (v.patterns[pattern] == nil || !v.patterns[pattern].MatchString(s)) {
+			v.fail(name, "must match %s", pattern)
+		}
+		v.checkEnum(name, s, schema)
+	case "integer", "number":
+		f, ok := v.number(name, value, schema["type"] == "integer")
+		if !ok {
+			return
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && f < minimum {
+			v.fail(name, "must be at least %v", minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && f > maximum {
+			v.fail(name, "must be at most %v", maximum)
+		}
+		v.checkEnum(name, f, schema)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(name, "must be true or false")
+		}
+	}
+}
+
+func (v *schemaValidator) checkObject(field string, object map[string]interface{}, schema map[string]interface{}) {
+	prefix := field
+	if prefix != "" {
+		prefix += "."
+	}
+	required, _ := schema["required"].([]interface{})
+	for _, r := range required {
+		name, _ := r.(string)
+		if _, present := object[name]; !present {
+			v.fail(prefix+name, "is required")
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			v.check(prefix+key, object[key], property)
+		} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			v.check(prefix+key, object[key], additional)
+		}
+	}
+}
+
+// number reads value as an integer or any number, reporting when it isn't one.
+func (v *schemaValidator) number(name string, value interface{}, integer bool) (float64, bool) {
+	number, ok := value.(json.Number)
+	if !integer {
+		f, err := number.Float64()
+		if !ok || err != nil {
+			v.fail(name, "must be a number")
+			return 0, false
+		}
+		return f, true
+	}
+	n, err := strconv.ParseInt(number.String(), 10, 64)
+	if numErr, isNumErr := err.(*strconv.NumError); ok && isNumErr && numErr.Err == strconv.ErrRange {
+		v.fail(name, "is out of range")
+		return 0, false
+	}
+	if !ok || err != nil {
+		v.fail(name, "must be an integer")
+		return 0, false
+	}
+	return float64(n), true
+}
+
+// checkEnum compares value, a string or a number as float64, to the enum of
+// schema. Strings match ignoring case, like element names; the handlers spell
+// them the document's way with specEnum.
+func (v *schemaValidator) checkEnum(name string, value interface{}, schema map[string]interface{}) {
+	enum, ok := schema["enum"].([]interface{})
+	if !ok {
+		return
+	}
+	allowed := make([]string, len(enum))
+	for i, option := range enum {
+		if option == value {
+			return
+		}
+		if s, ok := value.(string); ok && strings.EqualFold(fmt.Sprint(option), s) {
+			return
+		}
+		allowed[i] = fmt.Sprint(option)
+	}
+	v.fail(name, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+// specEnum spells value like the entry of the enum of property, in the schema
+// called schemaName, that it matches ignoring case. Values matching no entry
+// are returned as they are.
+func specEnum(schemaName, property, value string) string {
+	v := &schemaValidator{spec: openAPISpec}
+	schema := v.resolve(map[string]interface{}{"$ref": "#/components/schemas/" + schemaName})
+	properties, _ := schema["properties"].(map[string]interface{})
+	propertySchema, _ := properties[property].(map[string]interface{})
+	enum, _ := v.resolve(propertySchema)["enum"].([]interface{})
+	for _, option := range enum {
+		if s, ok := option.(string); ok && strings.EqualFold(s, value) {
+			return s
+		}
+	}
+	return value
+}
+
+// spellSettings spells the enum fields of req the way the document does, so
+// "bfs" or "Bidirectional" search like "BFS" and "bidirectional".
+func (req *SearchRequest) spellSettings() {
+	req.Algorithm = specEnum("SearchSettings", "algorithm", req.Algorithm)
+	req.SearchMode = specEnum("SearchSettings", "searchMode", req.SearchMode)
+	req.RankBy = specEnum("SearchSettings", "rankBy", req.RankBy)
+	req.Output = specEnum("SearchSettings", "output", req.Output)
+	if req.Costs != nil {
+		req.Costs.Default = specEnum("CostWeights", "default", req.Costs.Default)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIDocument checks that every reference of openapi.json resolves
+// and that its defaults and response versions are the ones the code uses.
+func TestOpenAPIDocument(t *testing.T) {
+	var walk func(path string, node interface{})
+	walk = func(path string, node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				var target interface{} = openAPISpec
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					object, _ := target.(map[string]interface{})
+					target = object[part]
+				}
+				if target == nil {
+					t.Errorf("%s: broken reference %s", path, ref)
+				}
+			}
+			for key, child := range node {
+				walk(path+"/"+key, child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(path, child)
+			}
+		}
+	}
+	walk("#", openAPISpec)
+
+	v := &schemaValidator{spec: openAPISpec}
+	schema := func(name string) map[string]interface{} {
+		return v.resolve(map[string]interface{}{"$ref": "#/components/schemas/" + name})
+	}
+	property := func(schema map[string]interface{}, name string) map[string]interface{} {
+		if allOf, ok := schema["allOf"].([]interface{}); ok {
+			schema = allOf[len(allOf)-1].(map[string]interface{})
+		}
+		return schema["properties"].(map[string]interface{})[name].(map[string]interface{})
+	}
+	limits := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"batch workers default", property(schema("BatchRequest"), "workers")["default"], float64(defaultBatchWorkers)},
+		{"enumerate limit default", v.resolve(specOperation("/api/enumerate", "GET")["parameters"].([]interface{})[1].(map[string]interface{}))["schema"].(map[string]interface{})["default"], float64(defaultPageSize)},
+		{"autocomplete limit default", v.resolve(specOperation("/api/autocomplete", "GET")["parameters"].([]interface{})[1].(map[string]interface{}))["schema"].(map[string]interface{})["default"], float64(defaultAutocompleteLimit)},
+		{"stream maxEvents default", v.resolve(specOperation("/api/search/stream", "GET")["parameters"].([]interface{})[9].(map[string]interface{}))["schema"].(map[string]interface{})["default"], float64(defaultStreamEvents)},
+		{"responseVersion enum", property(schema("SearchSettings"), "responseVersion")["enum"], []interface{}{float64(legacyResponseVersion), float64(currentResponseVersion)}},
+	}
+	for _, limit := range limits {
+		if !reflect.DeepEqual(limit.got, limit.want) {
+			t.Errorf("%s is %v in openapi.json, %v in the code", limit.name, limit.got, limit.want)
+		}
+	}
+}
+
+func TestValidatedRequests(t *testing.T) {
+	reached := false
+	mux := http.NewServeMux()
+	for _, route := range []string{"/api/search", "/api/batch", "/api/combined", "/api/verify", "/api/enumerate", "/api/uses/{element}", "/api/reachable", "/api/autocomplete", "/api/search/stream"} {
+		mux.HandleFunc(route, validated(route, func(w http.ResponseWriter, r *http.Request) { reached = true }))
+	}
+
+	cases := []struct {
+		method, url, body string
+		fields            []FieldError // nil when the request is valid
+	}{
+		{"POST", "/api/search", `{"target":"Brick","algorithm":"BFS","searchMode":"multiple","maxRecipes":3}`, nil},
+		{"POST", "/api/search", `{"target":"Brick","inventory":null,"costs":{"elements":{"Fire":2}}}`, nil},
+		{"POST", "/api/search", `{"target":"Brick","algorithm":"bfs","searchMode":"SINGLE","costs":{"default":"Unit"}}`, nil},
+		{"POST", "/api/search", `{"algorithm":"A*"}`, []FieldError{
+			{"algorithm", "must be one of BFS, DFS, bidirectional, Cost"},
+			{"target", "is required"},
+		}},
+		{"POST", "/api/search", `{"target":"","maxRecipes":101,"searchMode":"all"}`, []FieldError{
+			{"maxRecipes", "must be at most 100"},
+			{"searchMode", "must be one of single, multiple, kbest"},
+			{"target", "must not be empty"},
+		}},
+		{"POST", "/api/search", `{"target":"Brick","maxRecipes":"3","plan":1,"responseVersion":3}`, []FieldError{
+			{"maxRecipes", "must be an integer"},
+			{"plan", "must be true or false"},
+			{"responseVersion", "must be one of 1, 2"},
+		}},
+		{"POST", "/api/search", `{"target":"Brick","maxRecipes":1.5,"costs":{"elements":{"Fire":-1},"default":"free"}}`, []FieldError{
+			{"costs.default", "must be one of tier, unit"},
+			{"costs.elements.Fire", "must be at least 0"},
+			{"maxRecipes", "must be an integer"},
+		}},
+		{"POST", "/api/search", `["Brick"]`, []FieldError{{"body", "must be an object"}}},
+		{"POST", "/api/search", ``, []FieldError{{"body", "is required"}}},
+		{"POST", "/api/batch", `{"targets":[` + strings.Repeat(`"Mud",`, 1000) + `"Mud"]}`, []FieldError{
+			{"targets", "must have at most 1000 items"},
+		}},
+		{"POST", "/api/batch", `{"targets":[],"workers":-1}`, []FieldError{
+			{"targets", "must not be empty"},
+			{"workers", "must be at least 0"},
+		}},
+		{"POST", "/api/combined", `{"targets":["Brick",7],"output":"tree"}`, []FieldError{
+			{"output", "must be one of plan, dag"},
+			{"targets[1]", "must be a string"},
+		}},
+		{"POST", "/api/verify", `{"trees":[{"name":"Mud","children":[{"children":[]}]}]}`, []FieldError{
+			{"trees[0].children[0].name", "is required"},
+		}},
+		{"GET", "/api/enumerate?target=Brick&limit=5&cursor=MTA", "", nil},
+		{"GET", "/api/enumerate?limit=0&cursor=a%2Fb", "", []FieldError{
+			{"target", "is required"},
+			{"limit", "must be at least 1"},
+			{"cursor", "must match ^[A-Za-z0-9_-]*$"},
+		}},
+		{"GET", "/api/reachable?steps=all", "", nil},
+		{"GET", "/api/reachable?steps=0", "", []FieldError{
+			{"steps", "must match ^([1-9][0-9]*|all)$"},
+		}},
+		{"GET", "/api/uses/Mud", "", nil},
+		{"GET", "/api/autocomplete?q=ste", "", nil},
+		{"GET", "/api/search/stream?target=Brick&algorithm=DFS&maxRecipes=3&throttle=50", "", nil},
+		{"GET", "/api/search/stream?algorithm=A*&maxRecipes=500&throttle=-1", "", []FieldError{
+			{"target", "is required"},
+			{"algorithm", "must be one of BFS, DFS, bidirectional, Cost"},
+			{"maxRecipes", "must be at most 100"},
+			{"throttle", "must be at least 0"},
+		}},
+		{"GET", "/api/autocomplete?q=ste&limit=0", "", []FieldError{{"limit", "must be at least 1"}}},
+		{"OPTIONS", "/api/search", "", nil},
+	}
+	for _, c := range cases {
+		reached = false
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(c.method, c.url, strings.NewReader(c.body)))
+
+		if c.fields == nil {
+			if !reached {
+				t.Errorf("%s %s %s: rejected with %d %s", c.method, c.url, c.body, recorder.Code, recorder.Body)
+			}
+			continue
+		}
+		if reached || recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s %s %s: got status %d, want 400", c.method, c.url, c.body, recorder.Code)
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s %s: %v", c.method, c.url, c.body, err)
+			continue
+		}
+		if resp.Code != errInvalidRequest || !reflect.DeepEqual(resp.Fields, c.fields) {
+			t.Errorf("%s %s %s: got %s %v, want %s %v", c.method, c.url, c.body, resp.Code, resp.Fields, errInvalidRequest, c.fields)
+		}
+	}
+}
+
+// TestParseOpenAPISpec checks that every pattern is compiled when the document
+// is loaded, and that a broken one is an error instead of a panic later.
+func TestParseOpenAPISpec(t *testing.T) {
+	for _, pattern := range []string{"^[A-Za-z0-9_-]*$", "^([1-9][0-9]*|all)$"} {
+		if openAPIPatterns[pattern] == nil {
+			t.Errorf("pattern %s is not compiled", pattern)
+		}
+	}
+
+	document := `{"paths":{"/api/x":{"get":{"parameters":[{"name":"q","in":"query","schema":{"type":"string","pattern":"(unclosed"}}]}}}}`
+	if _, _, err := parseOpenAPISpec([]byte(document)); err == nil {
+		t.Errorf("parseOpenAPISpec accepted the pattern (unclosed")
+	}
+}
+
+// TestSpellSettings checks that enum settings in any case are spelled the way
+// the searches compare them.
+func TestSpellSettings(t *testing.T) {
+	req := SearchRequest{Algorithm: "bfs", SearchMode: "KBest", RankBy: "Steps", Output: "DAG", Costs: &CostWeights{Default: "UNIT"}}
+	req.spellSettings()
+	want := SearchRequest{Algorithm: "BFS", SearchMode: "kbest", RankBy: "steps", Output: "dag", Costs: &CostWeights{Default: "unit"}}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("got %+v, want %+v", req, want)
+	}
+	if got := specEnum("SearchSettings", "algorithm", "A*"); got != "A*" {
+		t.Errorf("unknown algorithm spelled as %s", got)
+	}
+}
+
+// TestCostModeRejected checks that asking the Cost search for several trees is
+// a field error on every endpoint that searches, not a single tree.
+func TestCostModeRejected(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	cases := []struct {
+		route, method, url, body string
+		handler                  http.HandlerFunc
+		status                   int
+	}{
+		{"/api/search", "POST", "/api/search", `{"target":"Brick","algorithm":"Cost","searchMode":"multiple","maxRecipes":3}`, searchHandler, http.StatusBadRequest},
+		{"/api/search", "POST", "/api/search", `{"target":"Brick","algorithm":"cost","searchMode":"KBEST"}`, searchHandler, http.StatusBadRequest},
+		{"/api/batch", "POST", "/api/batch", `{"targets":["Brick"],"algorithm":"Cost","searchMode":"kbest"}`, batchHandler, http.StatusBadRequest},
+		{"/api/search/stream", "GET", "/api/search/stream?target=Brick&algorithm=Cost&searchMode=multiple", "", searchStreamHandler, http.StatusBadRequest},
+		{"/api/search", "POST", "/api/search", `{"target":"Brick","algorithm":"Cost","searchMode":"single"}`, searchHandler, http.StatusOK},
+		{"/api/search", "POST", "/api/search", `{"target":"Brick","algorithm":"Cost"}`, searchHandler, http.StatusOK},
+	}
+	want := []FieldError{{"searchMode", "must be single with algorithm Cost"}}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		validated(c.route, c.handler)(recorder, httptest.NewRequest(c.method, c.url, strings.NewReader(c.body)))
+		if recorder.Code != c.status {
+			t.Errorf("%s %s: got status %d %s, want %d", c.url, c.body, recorder.Code, recorder.Body, c.status)
+			continue
+		}
+		if c.status != http.StatusBadRequest {
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s: %v", c.url, c.body, err)
+			continue
+		}
+		if resp.Code != errInvalidRequest || !reflect.DeepEqual(resp.Fields, want) {
+			t.Errorf("%s %s: got %s %v, want %s %v", c.url, c.body, resp.Code, resp.Fields, errInvalidRequest, want)
+		}
+	}
+}
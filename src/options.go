@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// requireScanLimit bounds how many enumerated trees are checked when no tree
+// from the chosen algorithm contains every required element.
+const requireScanLimit = 5000
+
+// SearchOptions holds the per-request constraints every algorithm honours.
+// The zero value searches from the four base elements, like before.
+type SearchOptions struct {
+	Inventory map[string]bool // owned elements, used as leaves like base elements
+	Exclude   map[string]bool // elements no tree may use
+	Require   []string        // elements every returned tree must contain
+
+	memo   *nodeMemo       // finished subtrees shared by the searches of one batch
+	events eventSink       // exploration events of a streamed search, see searchStreamHandler
+	ctx    context.Context // done once nobody waits for the search, see dispatchWithTimeout
+}
+
+func newSearchOptions(req SearchRequest) SearchOptions {
+	opts := SearchOptions{
+		Inventory: toElementSet(req.Inventory),
+		Exclude:   toElementSet(req.Exclude),
+	}
+	for _, element := range req.Require {
+		if element != "" {
+			opts.Require = append(opts.Require, element)
+		}
+	}
+	return opts
+}
+
+func toElementSet(elements []string) map[string]bool {
+	if len(elements) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(elements))
+	for _, element := range elements {
+		set[element] = true
+	}
+	return set
+}
+
+// splitElementList parses a comma separated query value such as "Mud,Stone".
+func splitElementList(raw string) []string {
+	var elements []string
+	for _, element := range strings.Split(raw, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeMemo returns the memo a search stores its finished subtrees in, a fresh
+// one unless the options share one across a batch.
+func (o SearchOptions) nodeMemo() *nodeMemo {
+	if o.memo != nil {
+		return o.memo
+	}
+	return newNodeMemo()
+}
+
+// cancelled reports whether nobody waits for the search any more, so its loops
+// can stop early. A search without a context runs to the end.
+func (o SearchOptions) cancelled() bool {
+	return o.ctx != nil && o.ctx.Err() != nil
+}
+
+// check if an element is a leaf: a base element or something already owned
+func (o SearchOptions) isLeaf(element string) bool {
+	if o.Exclude[element] {
+		return false
+	}
+	return isBase(element) || o.Inventory[element]
+}
+
+// changesRecipes reports whether the options change which recipes a search may
+// use. Only then does it need more than the precomputed index.
+func (o SearchOptions) changesRecipes() bool {
+	return len(o.Inventory) > 0 || len(o.Exclude) > 0
+}
+
+// recipesFor returns the recipe map a search for target walks, the closure
+// of target in the index. Owned elements lose their recipes, so every algorithm
+// stops expanding them, and recipes that need an excluded element (directly or
+// through every way of making an ingredient) are pruned.
+func (o SearchOptions) recipesFor(target string) map[string][][]string {
+	var recipes map[string][][]string
+	if lookupElement(target) != nil {
+		recipes = searchIndex.closure(target)
+	}
+	if !o.changesRecipes() {
+		return recipes
+	}
+
+	filtered := make(map[string][][]string, len(recipes))
+	for element, combs := range recipes {
+		if o.Inventory[element] || o.Exclude[element] {
+			continue
+		}
+		var kept [][]string
+		for _, pair := range combs {
+			if len(pair) == 2 && !o.Exclude[pair[0]] && !o.Exclude[pair[1]] {
+				kept = append(kept, pair)
+			}
+		}
+		if len(kept) > 0 {
+			filtered[element] = kept
+		}
+	}
+
+	if len(o.Exclude) > 0 {
+		o.pruneUnmakeable(filtered)
+	}
+	return filtered
+}
+
+// pruneUnmakeable drops recipes whose ingredients can no longer be made from
+// leaves, until every element left in the map has at least one usable recipe.
+func (o SearchOptions) pruneUnmakeable(recipes map[string][][]string) {
+	makeable := make(map[string]bool)
+	isMakeable := func(element string) bool {
+		return makeable[element] || o.isLeaf(element)
+	}
+
+	changed := true
+	for changed {
+		changed = false
+		for element, combs := range recipes {
+			if makeable[element] {
+				continue
+			}
+			for _, pair := range combs {
+				if isMakeable(pair[0]) && isMakeable(pair[1]) {
+					makeable[element] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for element, combs := range recipes {
+		var kept [][]string
+		for _, pair := range combs {
+			if isMakeable(pair[0]) && isMakeable(pair[1]) {
+				kept = append(kept, pair)
+			}
+		}
+		if len(kept) == 0 {
+			delete(recipes, element)
+		} else {
+			recipes[element] = kept
+		}
+	}
+}
+
+// validate rejects constraints no tree for target can satisfy before any
+// algorithm runs.
+func (o SearchOptions) validate(target string) error {
+	if o.Exclude[target] {
+		return fmt.Errorf("target %s is excluded", target)
+	}
+	for _, element := range o.Require {
+		if o.Exclude[element] {
+			return fmt.Errorf("%s is both required and excluded", element)
+		}
+	}
+	if len(o.Exclude) == 0 && len(o.Require) == 0 {
+		return nil
+	}
+
+	recipes := o.recipesFor(target)
+	if _, ok := recipes[target]; !ok && !o.isLeaf(target) {
+		if len(o.Exclude) > 0 {
+			return fmt.Errorf("no recipe for %s avoids %s", target, strings.Join(sortedKeys(o.Exclude), ", "))
+		}
+		return fmt.Errorf("no recipe for %s", target)
+	}
+
+	reachable := make(map[string]bool)
+	collectReachable(target, recipes, reachable)
+	for _, element := range o.Require {
+		if !reachable[element] {
+			return fmt.Errorf("no recipe tree for %s can contain %s", target, element)
+		}
+	}
+	return nil
+}
+
+// collectReachable marks every element that can appear in a tree for element.
+func collectReachable(element string, recipes map[string][][]string, reachable map[string]bool) {
+	if reachable[element] {
+		return
+	}
+	reachable[element] = true
+	for _, pair := range recipes[element] {
+		for _, ingredient := range pair {
+			collectReachable(ingredient, recipes, reachable)
+		}
+	}
+}
+
+// hasRequired reports whether a tree contains every required element.
+func (o SearchOptions) hasRequired(elements map[string]bool) bool {
+	for _, element := range o.Require {
+		if !elements[element] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRequire keeps the trees that contain every required element. When the
+// algorithm found none, the canonical enumeration is searched for some instead.
+func applyRequire(target string, result searchResult, opts SearchOptions) (searchResult, error) {
+	filtered := result.keepTrees(func(tree *TreeNode) bool {
+		elements := make(map[string]bool)
+		collectTreeNodeElements(tree, elements)
+		return tree != nil && opts.hasRequired(elements)
+	})
+	if len(filtered.trees) > 0 {
+		return filtered, nil
+	}
+
+	enumerator := newTreeEnumerator(target, opts)
+	var cursor uint64
+	for more := true; more && cursor < requireScanLimit && len(filtered.trees) < result.want; {
+		page := enumerator.page(cursor, result.want-len(filtered.trees))
+		cursor, more = page.next, page.more
+		for _, root := range page.roots {
+			filtered.trees = append(filtered.trees, convertToTreeNode(root))
+			if result.kind != bidirMultipleResult {
+				filtered.nodesVisited = append(filtered.nodesVisited, getPathElementCount(root))
+			}
+		}
+	}
+	if len(filtered.trees) == 0 {
+		return result, fmt.Errorf("no recipe tree for %s contains %s", target, strings.Join(opts.Require, ", "))
+	}
+	return filtered, nil
+}
+
+func collectTreeNodeElements(node *TreeNode, elements map[string]bool) {
+	if node == nil {
+		return
+	}
+	elements[node.Name] = true
+	for _, child := range node.Children {
+		collectTreeNodeElements(child, elements)
+	}
+}
+
+func collectNodeElements(node *Node, elements map[string]bool) {
+	if node == nil {
+		return
+	}
+	elements[node.element] = true
+	for _, recipe := range node.combinations {
+		collectNodeElements(recipe.ingredient1, elements)
+		collectNodeElements(recipe.ingredient2, elements)
+	}
+}
+
+// countNewCombinations counts the distinct elements that still have to be
+// crafted in each tree; leaves (base or owned) need no combination.
+func countNewCombinations(trees []*TreeNode) []int {
+	counts := make([]int, 0, len(trees))
+	for _, tree := range trees {
+		crafted := make(map[string]bool)
+		collectCrafted(tree, crafted)
+		counts = append(counts, len(crafted))
+	}
+	return counts
+}
+
+func collectCrafted(node *TreeNode, crafted map[string]bool) {
+	if node == nil || len(node.Children) == 0 {
+		return
+	}
+	crafted[node.Name] = true
+	for _, child := range node.Children {
+		collectCrafted(child, crafted)
+	}
+}
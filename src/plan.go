@@ -0,0 +1,84 @@
+package main
+
+import "strings"
+
+/*** LINEARIZED CRAFTING PLAN ***/
+
+// PlanStep is one combination in a crafting plan. Steps are numbered from 1 in
+// the order they can be done; an ingredient made by an earlier step names it
+// in Step, while base and owned elements have no step.
+type PlanStep struct {
+	Step        int              `json:"step"`
+	Ingredients []PlanIngredient `json:"ingredients"`
+	Result      string           `json:"result"`
+	Text        string           `json:"text"` // e.g. "Water + Fire → Steam"
+}
+
+type PlanIngredient struct {
+	Name string `json:"name"`
+	Step int    `json:"step,omitempty"`
+}
+
+// buildPlan orders the combinations of a tree so every ingredient is made
+// before it is used. An element is only crafted once: later uses point back to
+// the first step that made it, whatever recipe their own branch used.
+func buildPlan(root *TreeNode) []PlanStep {
+	plan := []PlanStep{}
+	if root == nil {
+		return plan
+	}
+	addPlanStep(root, &plan, make(map[string]int))
+	return plan
+}
+
+// addPlanStep adds the steps making node and returns the step that made it,
+// or 0 when node is a leaf.
+func addPlanStep(node *TreeNode, plan *[]PlanStep, made map[string]int) int {
+	if len(node.Children) == 0 {
+		return 0
+	}
+	if step, ok := made[node.Name]; ok {
+		return step
+	}
+
+	var ingredients []PlanIngredient
+	var names []string
+	for _, child := range node.Children {
+		if child == nil {
+			continue
+		}
+		ingredients = append(ingredients, PlanIngredient{Name: child.Name, Step: addPlanStep(child, plan, made)})
+		names = append(names, child.Name)
+	}
+
+	step := len(*plan) + 1
+	made[node.Name] = step
+	*plan = append(*plan, PlanStep{
+		Step:        step,
+		Ingredients: ingredients,
+		Result:      node.Name,
+		Text:        strings.Join(names, " + ") + " → " + node.Name,
+	})
+	return step
+}
+
+// buildCombinedPlan is one plan making every root, each element crafted once
+// across all of them.
+func buildCombinedPlan(roots []*TreeNode) []PlanStep {
+	plan := []PlanStep{}
+	made := make(map[string]int)
+	for _, root := range roots {
+		if root != nil {
+			addPlanStep(root, &plan, made)
+		}
+	}
+	return plan
+}
+
+func buildPlans(trees []*TreeNode) [][]PlanStep {
+	plans := make([][]PlanStep, 0, len(trees))
+	for _, tree := range trees {
+		plans = append(plans, buildPlan(tree))
+	}
+	return plans
+}
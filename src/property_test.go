@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// propertyDeadline bounds one search in the property tests. The graphs are
+// tiny, so a search running this long is not going to end.
+const propertyDeadline = 5 * time.Second
+
+// randomGraph is a recipe dataset small enough to search exhaustively. Recipes
+// draw ingredients from every element, so graphs have self recipes, cycles,
+// duplicate and mirrored pairs, and elements without recipes that nothing can
+// make.
+type randomGraph struct {
+	data OutputData
+}
+
+func (randomGraph) Generate(r *rand.Rand, size int) reflect.Value {
+	elements := []string{"Air", "Earth", "Fire", "Water"}
+	crafted := 3 + r.Intn(6)
+	for i := 0; i < crafted; i++ {
+		elements = append(elements, fmt.Sprintf("E%d", i))
+	}
+
+	data := OutputData{Elements: elements, Recipes: make(map[string]map[string][][]string)}
+	for _, element := range elements[4:] {
+		var recipes [][]string
+		for n := r.Intn(4); len(recipes) < n; {
+			pair := []string{elements[r.Intn(len(elements))], elements[r.Intn(len(elements))]}
+			recipes = append(recipes, pair)
+			if r.Intn(4) == 0 {
+				// the same pair again, sometimes mirrored
+				recipes = append(recipes, []string{pair[1-r.Intn(2)], pair[0]})
+			}
+		}
+		if len(recipes) > 0 {
+			data.Recipes[element] = map[string][][]string{element: recipes}
+		}
+	}
+	return reflect.ValueOf(randomGraph{data: data})
+}
+
+func (g randomGraph) String() string {
+	var lines []string
+	for element, recipes := range g.data.Recipes {
+		lines = append(lines, fmt.Sprintf("%s: %v", element, recipes[element]))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func (g randomGraph) load() {
+	recipeData = g.data
+	searchIndex = buildIndex(recipeData)
+	searchCache.clear()
+}
+
+// searchWithDeadline runs the whole request pipeline of /api/search and fails
+// when it has not answered within propertyDeadline.
+func searchWithDeadline(req SearchRequest) (searchResult, error) {
+	type outcome struct {
+		result searchResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := runSearch(req, newSearchOptions(req))
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-time.After(propertyDeadline):
+		return searchResult{}, fmt.Errorf("search did not finish within %s", propertyDeadline)
+	}
+}
+
+// checkSearch searches every element of g with req and returns the first
+// broken invariant.
+func checkSearch(g randomGraph, req SearchRequest) error {
+	g.load()
+	for _, element := range g.data.Elements {
+		req.Target = element
+		result, err := searchWithDeadline(req)
+		if err != nil {
+			var searchErr *SearchError
+			if !errors.As(err, &searchErr) || searchErr.Code == errTimeout || searchErr.Code == errSearchFailed {
+				return fmt.Errorf("%s: %v", element, err)
+			}
+			continue
+		}
+
+		if len(result.trees) == 0 {
+			return fmt.Errorf("%s: no trees and no error", element)
+		}
+		if len(result.trees) > max(req.MaxRecipes, 1) {
+			return fmt.Errorf("%s: %d trees, asked for %d", element, len(result.trees), req.MaxRecipes)
+		}
+		seen := make(map[string]bool)
+		for _, tree := range result.trees {
+			if problems := verifyTree(tree, SearchOptions{}); len(problems) > 0 {
+				return fmt.Errorf("%s: invalid tree %s at %s: %s", element, formatTree(tree), problems[0].Path, problems[0].Message)
+			}
+			if tree.Name != element {
+				return fmt.Errorf("%s: tree for %s", element, tree.Name)
+			}
+			signature := canonicalTree(tree)
+			if seen[signature] {
+				return fmt.Errorf("%s: tree %s returned twice", element, signature)
+			}
+			seen[signature] = true
+		}
+	}
+	return nil
+}
+
+func TestSearchProperties(t *testing.T) {
+	defer loadDataset(t, "testdata/fixture.json") // leave a known dataset behind
+
+	requests := []SearchRequest{
+		{Algorithm: "BFS", SearchMode: "single"},
+		{Algorithm: "DFS", SearchMode: "single"},
+		{Algorithm: "bidirectional", SearchMode: "single"},
+		{Algorithm: "Cost", SearchMode: "single"},
+		{Algorithm: "BFS", SearchMode: "multiple", MaxRecipes: 4},
+		{Algorithm: "DFS", SearchMode: "multiple", MaxRecipes: 4},
+		{Algorithm: "bidirectional", SearchMode: "multiple", MaxRecipes: 4},
+		{SearchMode: "kbest", MaxRecipes: 4},
+	}
+	for _, req := range requests {
+		t.Run(req.Algorithm+"/"+req.SearchMode, func(t *testing.T) {
+			property := func(g randomGraph) bool {
+				if err := checkSearch(g, req); err != nil {
+					t.Logf("graph:\n%s\n%v", g, err)
+					return false
+				}
+				return true
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 100}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestMultipleSearchDistinct checks the multiple searches themselves, before
+// the pipeline drops duplicates: each may only return a tree once by its own
+// signature, and never more trees than asked for.
+func TestMultipleSearchDistinct(t *testing.T) {
+	defer loadDataset(t, "testdata/fixture.json")
+
+	const maxRecipes = 4
+	searches := []struct {
+		name   string
+		search func(target string) []string // signatures of the trees found
+	}{
+		{"BFS", func(target string) []string {
+			trees, _ := searchBFSMultiple(target, maxRecipes, SearchOptions{})
+			var signatures []string
+			for _, tree := range trees {
+				signatures = append(signatures, serializeTree(tree.root))
+			}
+			return signatures
+		}},
+		{"DFS", func(target string) []string {
+			trees, _ := searchDFSMultiple(target, maxRecipes, SearchOptions{})
+			var signatures []string
+			for _, tree := range trees {
+				signatures = append(signatures, serializeTree(tree.root))
+			}
+			return signatures
+		}},
+		{"bidirectional", func(target string) []string {
+			trees, _ := searchBidirectionMultiple(target, maxRecipes, SearchOptions{})
+			var signatures []string
+			for _, tree := range trees {
+				signatures = append(signatures, formatTree(convertToTreeNode2(tree)))
+			}
+			return signatures
+		}},
+	}
+	for _, s := range searches {
+		t.Run(s.name, func(t *testing.T) {
+			property := func(g randomGraph) bool {
+				g.load()
+				for _, element := range g.data.Elements {
+					done := make(chan []string, 1)
+					go func() { done <- s.search(element) }()
+					var signatures []string
+					select {
+					case signatures = <-done:
+					case <-time.After(propertyDeadline):
+						t.Logf("graph:\n%s\n%s: search did not finish within %s", g, element, propertyDeadline)
+						return false
+					}
+
+					if len(signatures) > maxRecipes {
+						t.Logf("graph:\n%s\n%s: %d trees, asked for %d", g, element, len(signatures), maxRecipes)
+						return false
+					}
+					seen := make(map[string]bool)
+					for _, signature := range signatures {
+						if seen[signature] {
+							t.Logf("graph:\n%s\n%s: tree %s returned twice", g, element, signature)
+							return false
+						}
+						seen[signature] = true
+					}
+				}
+				return true
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 100}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestDFSMultipleDepthLimit checks that multiple DFS fails a branch deeper
+// than its depth limit instead of cutting it off with a leaf that isn't one.
+func TestDFSMultipleDepthLimit(t *testing.T) {
+	defer loadDataset(t, "testdata/fixture.json")
+
+	data := OutputData{Elements: []string{"Air", "Earth", "Fire", "Water"}, Recipes: make(map[string]map[string][][]string)}
+	previous := "Air"
+	for i := 0; i < 20; i++ {
+		element := fmt.Sprintf("E%d", i)
+		data.Elements = append(data.Elements, element)
+		data.Recipes[element] = map[string][][]string{element: {{previous, "Air"}}}
+		previous = element
+	}
+	randomGraph{data: data}.load()
+
+	for _, target := range []string{"E5", "E19"} {
+		trees, _ := searchDFSMultiple(target, 4, SearchOptions{})
+		for _, tree := range trees {
+			root := convertToTreeNode(tree.root)
+			if problems := verifyTree(root, SearchOptions{}); len(problems) > 0 {
+				t.Errorf("%s: invalid tree %s at %s: %s", target, formatTree(root), problems[0].Path, problems[0].Message)
+			}
+		}
+		if target == "E5" && len(trees) == 0 {
+			t.Errorf("%s: no tree within the depth limit", target)
+		}
+	}
+}
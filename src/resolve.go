@@ -0,0 +1,227 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*** ELEMENT NAME RESOLUTION AND AUTOCOMPLETE ***/
+
+const (
+	maxSuggestions           = 5  // "did you mean" names of an unknown element
+	defaultAutocompleteLimit = 10 // matches per autocomplete answer
+	maxAutocompleteLimit     = 50
+)
+
+// normalizeName folds case and whitespace, so "  steam   ENGINE" and
+// "Steam Engine" are the same name.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// resolveName returns the element name is meant to be, or name itself when
+// no element matches it.
+func resolveName(name string) string {
+	if element, ok := canonicalName(name); ok {
+		return element
+	}
+	return name
+}
+
+// resolveNames resolves every name of a list like resolveName.
+func resolveNames(names []string) []string {
+	for i, name := range names {
+		names[i] = resolveName(name)
+	}
+	return names
+}
+
+// editDistance is the edit distance between a and b, counting runes: the
+// insertions, deletions, substitutions and swaps of adjacent runes to turn one
+// into the other, so "Fier" is one typo away from "Fire".
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// three rows of the table: before the previous rune of a, the previous, and this one
+	before, prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j], cur[j-1])+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], before[j-2]+1)
+			}
+		}
+		before, prev, cur = prev, cur, before
+	}
+	return prev[len(rb)]
+}
+
+// maxTypos is how far a name of n runes may be from an element to still
+// suggest it: one typo for short names, about one in four runes for longer.
+func maxTypos(n int) int {
+	return max(1, n/4)
+}
+
+// suggestNames lists up to limit elements close to name by edit distance,
+// closest first.
+func suggestNames(name string, limit int) []string {
+	query := normalizeName(name)
+	if query == "" {
+		return nil
+	}
+	typos := maxTypos(utf8.RuneCountInString(query))
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, element := range searchIndex.names {
+		folded := normalizeName(element)
+		if diff := utf8.RuneCountInString(folded) - utf8.RuneCountInString(query); diff > typos || -diff > typos {
+			continue
+		}
+		if d := editDistance(query, folded); d <= typos {
+			candidates = append(candidates, candidate{element, d})
+		}
+	}
+	// names are already sorted, a stable sort keeps ties alphabetical
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	var names []string
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// unknownElementError is the errUnknownElement of name, suggesting the
+// elements it may be a typo of.
+func unknownElementError(name string) *SearchError {
+	err := &SearchError{Code: errUnknownElement, Element: name, Message: "unknown element " + name}
+	if err.Suggestions = suggestNames(name, maxSuggestions); len(err.Suggestions) > 0 {
+		err.Message += ", did you mean " + strings.Join(err.Suggestions, ", ") + "?"
+	}
+	return err
+}
+
+// How an autocomplete match relates to the query, best first.
+const (
+	matchExact    = "exact"
+	matchPrefix   = "prefix"   // the name starts with the query
+	matchWord     = "word"     // a later word of the name starts with the query
+	matchContains = "contains" // the query is somewhere inside the name
+	matchFuzzy    = "fuzzy"    // within maxTypos edits of the query
+)
+
+var matchRank = map[string]int{matchExact: 0, matchPrefix: 1, matchWord: 2, matchContains: 3, matchFuzzy: 4}
+
+type AutocompleteMatch struct {
+	Name     string `json:"name"`
+	Tier     int    `json:"tier"`
+	Match    string `json:"match"`
+	Distance int    `json:"distance,omitempty"` // fuzzy matches: edits from the query
+}
+
+type AutocompleteResponse struct {
+	Query   string              `json:"query"`
+	Matches []AutocompleteMatch `json:"matches"`
+}
+
+// autocomplete ranks the elements matching query: exact names, then names
+// starting with it, names with a word starting with it, names containing it
+// and finally names within a few typos of it. Ties go to the lower tier, as
+// players look for the common elements first, then by name.
+func autocomplete(query string, limit int) []AutocompleteMatch {
+	normalized := normalizeName(query)
+	matches := []AutocompleteMatch{}
+	if normalized == "" {
+		return matches
+	}
+	typos := maxTypos(utf8.RuneCountInString(normalized))
+
+	for _, element := range searchIndex.names {
+		folded := normalizeName(element)
+		match := AutocompleteMatch{Name: element, Tier: lookupElement(element).Tier}
+		switch {
+		case folded == normalized:
+			match.Match = matchExact
+		case strings.HasPrefix(folded, normalized):
+			match.Match = matchPrefix
+		case strings.Contains(folded, " "+normalized):
+			match.Match = matchWord
+		case strings.Contains(folded, normalized):
+			match.Match = matchContains
+		default:
+			// typos in what has been typed so far: compare with the start of the name
+			runes := []rune(folded)
+			prefix := string(runes[:min(len(runes), utf8.RuneCountInString(normalized))])
+			d := min(editDistance(normalized, folded), editDistance(normalized, prefix))
+			if d > typos {
+				continue
+			}
+			match.Match, match.Distance = matchFuzzy, d
+		}
+		matches = append(matches, match)
+	}
+
+	// unmakeable elements (tier -1) sort after every tier
+	tier := func(m AutocompleteMatch) int {
+		if m.Tier < 0 {
+			return math.MaxInt
+		}
+		return m.Tier
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if matchRank[a.Match] != matchRank[b.Match] {
+			return matchRank[a.Match] < matchRank[b.Match]
+		}
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return tier(a) < tier(b)
+	})
+	return matches[:min(limit, len(matches))]
+}
+
+// GET /api/autocomplete?q=ste&limit=10
+func autocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultAutocompleteLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAutocompleteLimit)
+	}
+
+	q := query.Get("q")
+	matches := autocomplete(q, limit)
+	log.Printf("Autocomplete for '%s': %d matches\n", q, len(matches))
+	writeJSON(w, http.StatusOK, AutocompleteResponse{Query: q, Matches: matches})
+}
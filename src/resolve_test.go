@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveName(t *testing.T) {
+	loadDataset(t, "testdata/recipes.json.gz")
+
+	cases := []struct {
+		name, want string
+	}{
+		{"Steam", "Steam"},
+		{"steam engine", "Steam engine"},
+		{"  STEAM\tengine ", "Steam engine"},
+		{"black   Hole", "Black hole"},
+		{"Steamengine", "Steamengine"},
+		{"Steem", "Steem"},
+	}
+	for _, c := range cases {
+		if got := resolveName(c.name); got != c.want {
+			t.Errorf("resolveName(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSuggestNames(t *testing.T) {
+	loadDataset(t, "testdata/recipes.json.gz")
+
+	cases := []struct {
+		name string
+		want []string
+	}{
+		{"Steem", []string{"Steam", "Steel"}},
+		{"Steamengine", []string{"Steam engine"}},
+		{"steem engin", []string{"Steam engine"}},
+		{"Chiken", []string{"Chicken"}},
+		{"Fier", []string{"Fire"}},
+		{"Unobtainium", nil},
+		{"", nil},
+	}
+	for _, c := range cases {
+		if got := suggestNames(c.name, maxSuggestions); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("suggestNames(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	err := diagnoseTarget("Steem", SearchOptions{}).(*SearchError)
+	if err.Code != errUnknownElement || !reflect.DeepEqual(err.Suggestions, []string{"Steam", "Steel"}) || !strings.Contains(err.Message, "did you mean Steam, Steel?") {
+		t.Errorf("Steem: got %+v", err)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	cases := []struct {
+		query string
+		want  []AutocompleteMatch
+	}{
+		{"st", []AutocompleteMatch{
+			{Name: "Steam", Tier: 1, Match: matchPrefix},
+			{Name: "Stone", Tier: 2, Match: matchPrefix},
+		}},
+		{"  OLE", []AutocompleteMatch{
+			{Name: "Blackhole", Tier: -1, Match: matchContains},
+		}},
+		{"mud", []AutocompleteMatch{
+			{Name: "Mud", Tier: 1, Match: matchExact},
+		}},
+		{"lvaa", []AutocompleteMatch{
+			{Name: "Lava", Tier: 1, Match: matchFuzzy, Distance: 1},
+		}},
+		{"", []AutocompleteMatch{}},
+	}
+	for _, c := range cases {
+		if got := autocomplete(c.query, defaultAutocompleteLimit); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("autocomplete(%q) = %+v, want %+v", c.query, got, c.want)
+		}
+	}
+	if got := autocomplete("e", 3); len(got) != 3 {
+		t.Errorf("limit 3: got %d matches", len(got))
+	}
+
+	loadDataset(t, "testdata/recipes.json.gz")
+	want := []AutocompleteMatch{
+		{Name: "Engineer", Tier: 10, Match: matchPrefix},
+		{Name: "Combustion engine", Tier: 10, Match: matchWord},
+		{Name: "Steam engine", Tier: 10, Match: matchWord},
+	}
+	if got := autocomplete("ENGINE", maxAutocompleteLimit); !reflect.DeepEqual(got, want) {
+		t.Errorf("autocomplete(ENGINE) = %+v, want %+v", got, want)
+	}
+}
+
+// TestSearchResolvesTarget checks that the search handler accepts names in
+// any case and answers unknown ones with suggestions.
+func TestSearchResolvesTarget(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	recorder := httptest.NewRecorder()
+	searchHandler(recorder, httptest.NewRequest("POST", "/api/search", strings.NewReader(`{"target":" mud ","algorithm":"BFS"}`)))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"name":"Mud"`) {
+		t.Errorf("mud: got %d %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	searchHandler(recorder, httptest.NewRequest("POST", "/api/search", strings.NewReader(`{"target":"Steem","algorithm":"BFS"}`)))
+	if recorder.Code != http.StatusNotFound || !strings.Contains(recorder.Body.String(), `"suggestions":["Steam"]`) {
+		t.Errorf("Steem: got %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+// TestElementEndpointsResolveNames checks that the endpoints taking element
+// names accept them in any case, like the search handler.
+func TestElementEndpointsResolveNames(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/uses/{element}", usesHandler)
+	mux.HandleFunc("/api/reachable", reachableHandler)
+	mux.HandleFunc("/api/closure", closureHandler)
+	cases := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/api/uses/mud", http.StatusOK, `"element":"Mud"`},
+		{"/api/uses/Steem", http.StatusNotFound, `"suggestions":["Steam"]`},
+		{"/api/reachable?owned=mud,%20LAVA", http.StatusOK, `"owned":["Mud","Lava"]`},
+		{"/api/reachable?owned=Steem", http.StatusBadRequest, "unknown element Steem"},
+		{"/api/closure?start=water,FIRE", http.StatusOK, `"start":["Water","Fire"]`},
+		{"/api/closure?start=Steem", http.StatusBadRequest, "unknown element Steem"},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", c.path, nil))
+		if recorder.Code != c.status || !strings.Contains(recorder.Body.String(), c.want) {
+			t.Errorf("%s: got %d %s", c.path, recorder.Code, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*** VERSIONED SEARCH RESPONSE ***/
+
+// Response versions a request can ask for with responseVersion. Version 1 is
+// the legacy shape that differs by mode: SearchResponse with "tree",
+// MultipleSearchResponse with "trees", and a single nodesVisited number for
+// multiple bidirectional search.
+const (
+	legacyResponseVersion  = 1
+	currentResponseVersion = 2
+)
+
+// defaultResponseVersion answers requests without responseVersion, see
+// SEARCH_RESPONSE_VERSION.
+var defaultResponseVersion = currentResponseVersion
+
+// responseVersionFromEnv reads SEARCH_RESPONSE_VERSION, letting a deployment
+// keep the legacy shapes for clients that don't send responseVersion yet.
+func responseVersionFromEnv() int {
+	raw := os.Getenv("SEARCH_RESPONSE_VERSION")
+	if raw == "" {
+		return currentResponseVersion
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || validateResponseVersion(version) != nil || version == 0 {
+		log.Fatalf("invalid SEARCH_RESPONSE_VERSION %q, want %d or %d", raw, legacyResponseVersion, currentResponseVersion)
+	}
+	return version
+}
+
+func validateResponseVersion(version int) error {
+	if version != 0 && version != legacyResponseVersion && version != currentResponseVersion {
+		return fmt.Errorf("responseVersion must be %d or %d", legacyResponseVersion, currentResponseVersion)
+	}
+	return nil
+}
+
+// SearchResponseV2 is the answer of every algorithm and mode. TreeStats has
+// one entry per tree, also when DAGs replace the trees.
+type SearchResponseV2 struct {
+	Version        int          `json:"version"`
+	Algorithm      string       `json:"algorithm"`  // the algorithm that ran, see resolveAlgorithm
+	SearchMode     string       `json:"searchMode"` // "single", "multiple" or "kbest"
+	DatasetVersion string       `json:"datasetVersion"`
+	Trees          []*TreeNode  `json:"trees"`
+	TreeStats      []TreeStats  `json:"treeStats"`
+	Stats          SearchStats  `json:"stats"`
+	DAGs           []*RecipeDAG `json:"dags,omitempty"`
+	Plans          [][]PlanStep `json:"plan,omitempty"`
+	Warnings       []string     `json:"warnings"`
+}
+
+type TreeStats struct {
+	NodesVisited    *int     `json:"nodesVisited,omitempty"` // not per tree for multiple bidirectional search
+	NewCombinations int      `json:"newCombinations"`
+	Nodes           int      `json:"nodes"`
+	Cost            *float64 `json:"cost,omitempty"`  // Cost algorithm only
+	Score           *int     `json:"score,omitempty"` // kbest mode only
+}
+
+type SearchStats struct {
+	TreesFound     int     `json:"treesFound"`
+	TreesRequested int     `json:"treesRequested"`
+	NodesVisited   int     `json:"nodesVisited"` // summed over the trees, or the shared count
+	Cache          string  `json:"cache"`        // "hit" when served from the result cache, else "miss"
+	ExecutionTime  float64 `json:"executionTime"`
+}
+
+// resolveAlgorithm names the algorithm and mode dispatchSearch runs for req,
+// which is not always what was asked for: missing names default to multiple
+// bidirectional search, and a multiple BFS or DFS search for one tree runs the
+// single search.
+func resolveAlgorithm(req SearchRequest) (algorithm, mode string) {
+	switch {
+	case req.Algorithm == "Cost":
+		return "Cost", "single"
+	case req.SearchMode == "kbest":
+		return "kbest", "kbest"
+	case req.SearchMode == "single":
+		if req.Algorithm == "DFS" || req.Algorithm == "BFS" {
+			return req.Algorithm, "single"
+		}
+		return "bidirectional", "single"
+	case req.Algorithm == "DFS" || req.Algorithm == "BFS":
+		if req.MaxRecipes <= 1 {
+			return req.Algorithm, "single"
+		}
+		return req.Algorithm, "multiple"
+	default:
+		return "bidirectional", "multiple"
+	}
+}
+
+// searchWarnings explains where the answer differs from what req asked for.
+func searchWarnings(req SearchRequest, r searchResult) []string {
+	warnings := []string{}
+	algorithm, mode := resolveAlgorithm(req)
+	if mode == "single" && req.MaxRecipes > 1 {
+		warnings = append(warnings, fmt.Sprintf("%s %s search returns one tree, maxRecipes %d is ignored", algorithm, mode, req.MaxRecipes))
+	}
+	if r.approximate {
+		warnings = append(warnings, fmt.Sprintf("approxElements ranked the first %d trees only, a larger tree may use fewer distinct elements", kBestPool))
+	}
+	if len(r.unfinished) > 0 {
+		var leaves []string
+		seen := make(map[string]bool)
+		for _, leaf := range r.unfinished {
+			if !seen[leaf] {
+				seen[leaf] = true
+				leaves = append(leaves, leaf)
+			}
+		}
+		warnings = append(warnings, fmt.Sprintf("dropped %d trees the search left unfinished at %s", len(r.unfinished), strings.Join(leaves, ", ")))
+	}
+	if len(r.trees) < r.want {
+		warnings = append(warnings, fmt.Sprintf("found %d of %d requested trees", len(r.trees), r.want))
+	}
+	return warnings
+}
+
+// countTreeNodes is the number of nodes of a tree, leaves included.
+func countTreeNodes(node *TreeNode) int {
+	if node == nil {
+		return 0
+	}
+	count := 1
+	for _, child := range node.Children {
+		count += countTreeNodes(child)
+	}
+	return count
+}
+
+// responseV2 shapes r as a SearchResponseV2 answering req.
+func (r searchResult) responseV2(req SearchRequest, executionTime float64) SearchResponseV2 {
+	algorithm, mode := resolveAlgorithm(req)
+	resp := SearchResponseV2{
+		Version:        currentResponseVersion,
+		Algorithm:      algorithm,
+		SearchMode:     mode,
+		DatasetVersion: searchIndex.version,
+		Trees:          r.trees,
+		TreeStats:      []TreeStats{},
+		Stats: SearchStats{
+			TreesFound:     len(r.trees),
+			TreesRequested: r.want,
+			Cache:          "miss",
+			ExecutionTime:  executionTime,
+		},
+		Warnings: searchWarnings(req, r),
+	}
+	if r.cached {
+		resp.Stats.Cache = "hit"
+	}
+	if r.dag {
+		resp.DAGs = buildRecipeDAGs(r.trees)
+		resp.Trees = []*TreeNode{}
+	}
+	if r.plan {
+		resp.Plans = buildPlans(r.trees)
+	}
+
+	newCombinations := countNewCombinations(r.trees)
+	for i, tree := range r.trees {
+		stats := TreeStats{NewCombinations: newCombinations[i], Nodes: countTreeNodes(tree)}
+		if r.kind != bidirMultipleResult && i < len(r.nodesVisited) {
+			stats.NodesVisited = &r.nodesVisited[i]
+			resp.Stats.NodesVisited += r.nodesVisited[i]
+		}
+		if r.costs != nil {
+			stats.Cost = &r.costs[i]
+		}
+		if r.scores != nil {
+			stats.Score = &r.scores[i]
+		}
+		resp.TreeStats = append(resp.TreeStats, stats)
+	}
+	if r.kind == bidirMultipleResult && len(r.nodesVisited) > 0 {
+		resp.Stats.NodesVisited = r.nodesVisited[0]
+	}
+	return resp
+}
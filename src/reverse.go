@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*** REVERSE SEARCH ***/
+
+// Use is one recipe an element takes part in: combined With the other
+// ingredient, it makes Result.
+type Use struct {
+	With   string `json:"with"`
+	Result string `json:"result"`
+}
+
+// buildUsesIndex maps every element to the recipes it is an ingredient of.
+func buildUsesIndex(data OutputData) map[string][]Use {
+	index := make(map[string][]Use)
+	for element, recipeMap := range data.Recipes {
+		for _, pair := range recipeMap[element] {
+			if len(pair) != 2 {
+				continue
+			}
+			index[pair[0]] = append(index[pair[0]], Use{With: pair[1], Result: element})
+			if pair[0] != pair[1] {
+				index[pair[1]] = append(index[pair[1]], Use{With: pair[0], Result: element})
+			}
+		}
+	}
+	for _, uses := range index {
+		sort.Slice(uses, func(i, j int) bool {
+			if uses[i].Result != uses[j].Result {
+				return uses[i].Result < uses[j].Result
+			}
+			return uses[i].With < uses[j].With
+		})
+	}
+	return index
+}
+
+// ReachableElement is an element that can be crafted from the owned set. Step
+// is the first round it can be made in and Recipe a pair of ingredients that
+// were all available before that round.
+type ReachableElement struct {
+	Name   string    `json:"name"`
+	Step   int       `json:"step"`
+	Recipe [2]string `json:"recipe"`
+}
+
+// reachableFrom crafts in rounds from owned plus the base elements. Each round
+// makes everything whose ingredients were available after the previous round,
+// for at most steps rounds, or until nothing new can be made when steps <= 0.
+func reachableFrom(owned []string, steps int) []ReachableElement {
+	available := map[string]bool{"Air": true, "Earth": true, "Fire": true, "Water": true}
+	for _, element := range owned {
+		available[element] = true
+	}
+	frontier := sortedKeys(available)
+
+	var reachable []ReachableElement
+	for step := 1; len(frontier) > 0 && (steps <= 0 || step <= steps); step++ {
+		made := make(map[string][2]string)
+		for _, element := range frontier {
+			for _, use := range lookupElement(element).Uses {
+				if !available[use.With] || available[use.Result] {
+					continue
+				}
+				if _, ok := made[use.Result]; !ok {
+					made[use.Result] = [2]string{element, use.With}
+				}
+			}
+		}
+
+		frontier = make([]string, 0, len(made))
+		for element := range made {
+			frontier = append(frontier, element)
+		}
+		sort.Strings(frontier)
+		for _, element := range frontier {
+			available[element] = true
+			reachable = append(reachable, ReachableElement{Name: element, Step: step, Recipe: made[element]})
+		}
+	}
+	return reachable
+}
+
+type UsesResponse struct {
+	Element string `json:"element"`
+	Uses    []Use  `json:"uses"`
+}
+
+type ReachableResponse struct {
+	Owned     []string           `json:"owned"`
+	Steps     int                `json:"steps"`
+	Reachable []ReachableElement `json:"reachable"`
+}
+
+func usesHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	element := resolveName(r.PathValue("element"))
+	info := lookupElement(element)
+	if info == nil {
+		writeSearchError(w, unknownElementError(element))
+		return
+	}
+	log.Printf("Listing uses of '%s'\n", element)
+	uses := info.Uses
+	if uses == nil {
+		uses = []Use{}
+	}
+	writeJSON(w, http.StatusOK, UsesResponse{Element: element, Uses: uses})
+}
+
+// reachableHandler serves GET /api/reachable?owned=Mud,Stone&steps=2. Base
+// elements are always owned; steps defaults to 1 and "all" crafts until
+// nothing new can be made.
+func reachableHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	owned := resolveNames(splitElementList(query.Get("owned")))
+	var unknown []string
+	for _, element := range owned {
+		if lookupElement(element) == nil {
+			unknown = append(unknown, element)
+		}
+	}
+	if len(unknown) > 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "unknown element " + strings.Join(unknown, ", ")})
+		return
+	}
+
+	steps := 1
+	if raw := query.Get("steps"); raw == "all" {
+		steps = 0
+	} else if raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid steps"}`, http.StatusBadRequest)
+			return
+		}
+		steps = n
+	}
+
+	log.Printf("Listing elements reachable from %v in %d steps\n", owned, steps)
+	reachable := reachableFrom(owned, steps)
+	if reachable == nil {
+		reachable = []ReachableElement{}
+	}
+	if owned == nil {
+		owned = []string{}
+	}
+	writeJSON(w, http.StatusOK, ReachableResponse{Owned: owned, Steps: steps, Reachable: reachable})
+}
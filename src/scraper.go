@@ -183,6 +183,8 @@ func ScrapeRecipes() (OutputData, error) {
 		}
 	})
 
+	reportDroppedElements(allRecipes, result)
+
 	fmt.Printf("Total elements: %d, Total elements with recipes: %d\n",
 		len(result.Elements), len(result.Recipes))
 
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*** SEARCH PROGRESS STREAMING ***/
+
+// Types of a SearchEvent.
+const (
+	eventVisit = "visit" // the search looks at the recipes of Element
+	eventPush  = "push"  // Element joins the queue, stack or heap
+	eventPop   = "pop"   // Element leaves it
+	eventMeet  = "meet"  // the bidirectional sides met at Element
+	eventTree  = "tree"  // a tree of the answer, sent once the search is done
+)
+
+// Sides of a bidirectional SearchEvent.
+const (
+	sideForward  = "forward"  // from the target down
+	sideBackward = "backward" // from the leaves up
+)
+
+const (
+	defaultStreamEvents = 10000 // exploration events per stream
+	maxStreamEvents     = 100000
+	maxStreamThrottle   = 1000 // ms between events
+)
+
+// SearchEvent is one step of a search. The algorithms fill in what they know;
+// Seq and Elapsed are stamped by the stream.
+type SearchEvent struct {
+	Seq     int       `json:"seq"`
+	Type    string    `json:"type"`
+	Element string    `json:"element,omitempty"`
+	Parent  string    `json:"parent,omitempty"` // push: the element Element was reached from
+	Side    string    `json:"side,omitempty"`   // bidirectional: sideForward or sideBackward
+	Depth   int       `json:"depth,omitempty"`  // distance from where the search (or side) started
+	Tree    *TreeNode `json:"tree,omitempty"`
+	Elapsed float64   `json:"elapsed"` // ms since the search started
+}
+
+// eventSink receives the events of a search, from several goroutines in the
+// multiple searches. A nil sink drops them, so only streamed searches pay for
+// more than the nil check.
+type eventSink func(SearchEvent)
+
+func (s eventSink) emit(event SearchEvent) {
+	if s != nil {
+		s(event)
+	}
+}
+
+// StreamStart is the first event of a stream: what will run.
+type StreamStart struct {
+	Target     string `json:"target"`
+	Algorithm  string `json:"algorithm"`
+	SearchMode string `json:"searchMode"`
+	Throttle   int    `json:"throttle"`
+	MaxEvents  int    `json:"maxEvents"`
+}
+
+// StreamDone is the last event of a stream.
+type StreamDone struct {
+	Events  int `json:"events"`  // events recorded, trees included
+	Dropped int `json:"dropped"` // exploration events past maxEvents, never sent
+}
+
+// eventRecorder buffers the events of one search until the stream sends them.
+// The search never waits for the client: past limit exploration events are
+// counted and dropped, and after close everything is ignored, as a search
+// that timed out keeps running in the background.
+type eventRecorder struct {
+	mu       sync.Mutex
+	start    time.Time
+	limit    int
+	pending  []SearchEvent
+	recorded int // events with a Seq
+	explored int // recorded events that are not trees
+	dropped  int
+	closed   bool
+	notify   chan struct{} // signalled when pending gets events
+}
+
+func newEventRecorder(limit int) *eventRecorder {
+	return &eventRecorder{start: time.Now(), limit: limit, notify: make(chan struct{}, 1)}
+}
+
+func (rec *eventRecorder) sink() eventSink {
+	return func(event SearchEvent) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.closed {
+			return
+		}
+		// trees don't count against the limit, they are the answer
+		if event.Type != eventTree {
+			if rec.explored >= rec.limit {
+				rec.dropped++
+				return
+			}
+			rec.explored++
+		}
+		rec.recorded++
+		event.Seq = rec.recorded
+		event.Elapsed = float64(time.Since(rec.start).Microseconds()) / 1000
+		rec.pending = append(rec.pending, event)
+		select {
+		case rec.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take hands over the pending events.
+func (rec *eventRecorder) take() []SearchEvent {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	events := rec.pending
+	rec.pending = nil
+	return events
+}
+
+func (rec *eventRecorder) close() StreamDone {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.closed = true
+	return StreamDone{Events: rec.recorded, Dropped: rec.dropped}
+}
+
+// writeEvent writes one Server-Sent Event with data as JSON. id is left out
+// when 0.
+func writeEvent(w io.Writer, id int, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+	return err
+}
+
+// streamSearchRequest reads the search settings of a stream from the query.
+func streamSearchRequest(r *http.Request) (SearchRequest, error) {
+	query := r.URL.Query()
+	req := SearchRequest{
+		Target:     query.Get("target"),
+		Algorithm:  query.Get("algorithm"),
+		SearchMode: query.Get("searchMode"),
+		RankBy:     query.Get("rankBy"),
+		Inventory:  splitElementList(query.Get("inventory")),
+		Exclude:    splitElementList(query.Get("exclude")),
+		Require:    splitElementList(query.Get("require")),
+	}
+	if req.Target == "" {
+		return req, fmt.Errorf("missing target")
+	}
+	if raw := query.Get("maxRecipes"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return req, fmt.Errorf("invalid maxRecipes")
+		}
+		req.MaxRecipes = n
+	}
+	req.spellSettings()
+	return req, nil
+}
+
+// GET /api/search/stream?target=Brick&algorithm=BFS&searchMode=single&throttle=50&maxEvents=1000
+//
+// Server-Sent Events: "start", then the SearchEvents of the search as they
+// happen, each under its type, then "result" with the /api/search answer or
+// "error" with its error response, and "done". throttle is the least number
+// of ms between two exploration events, for animating them; the search runs
+// at full speed and the events wait in a buffer of at most maxEvents.
+func searchStreamHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "GET, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "streaming is not supported"})
+		return
+	}
+
+	req, err := streamSearchRequest(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := costModeError(req); err != nil {
+		writeSearchError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	throttle := 0
+	if raw := query.Get("throttle"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid throttle"})
+			return
+		}
+		throttle = min(n, maxStreamThrottle)
+	}
+	maxEvents := defaultStreamEvents
+	if raw := query.Get("maxEvents"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid maxEvents"})
+			return
+		}
+		maxEvents = min(n, maxStreamEvents)
+	}
+
+	log.Printf("Streaming search for '%s' using algorithm: %s, mode: %s, throttle: %dms\n",
+		req.Target, req.Algorithm, req.SearchMode, throttle)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	algorithm, mode := resolveAlgorithm(req)
+	writeEvent(w, 0, "start", StreamStart{
+		Target: resolveName(req.Target), Algorithm: algorithm, SearchMode: mode,
+		Throttle: throttle, MaxEvents: maxEvents,
+	})
+	flusher.Flush()
+
+	rec := newEventRecorder(maxEvents)
+	defer rec.close()
+	opts := newSearchOptions(req)
+	opts.events = rec.sink()
+
+	type outcome struct {
+		result        searchResult
+		err           error
+		executionTime float64
+	}
+	done := make(chan outcome, 1)
+	startTime := time.Now()
+	go func() {
+		result, err := runSearch(req, opts)
+		executionTime := float64(time.Since(startTime).Milliseconds())
+		for _, tree := range result.trees {
+			opts.events.emit(SearchEvent{Type: eventTree, Element: tree.Name, Tree: tree})
+		}
+		done <- outcome{result, err, executionTime}
+	}()
+
+	// send writes pending events, waiting throttle ms before each one. It
+	// stops when the client goes away.
+	send := func() bool {
+		for _, event := range rec.take() {
+			if throttle > 0 && event.Type != eventTree {
+				select {
+				case <-time.After(time.Duration(throttle) * time.Millisecond):
+				case <-r.Context().Done():
+					return false
+				}
+			}
+			if err := writeEvent(w, event.Seq, event.Type, event); err != nil {
+				log.Printf("Failed to write search event: %v\n", err)
+				return false
+			}
+			flusher.Flush()
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("Stream for '%s' closed by the client\n", req.Target)
+			return
+		case <-rec.notify:
+			if !send() {
+				return
+			}
+		case o := <-done:
+			if !send() {
+				return
+			}
+			if o.err != nil {
+				_, resp := searchErrorResponse(o.err)
+				writeEvent(w, 0, "error", resp)
+			} else {
+				writeEvent(w, 0, "result", o.result.response(req, o.executionTime))
+			}
+			writeEvent(w, 0, "done", rec.close())
+			flusher.Flush()
+			return
+		}
+	}
+}
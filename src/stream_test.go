@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// streamEvents serves GET path through searchStreamHandler and splits the
+// answer into its events.
+func streamEvents(t *testing.T, path string) []sseEvent {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	validated("/api/search/stream", searchStreamHandler)(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != 200 {
+		t.Fatalf("GET %s: status %d %s", path, recorder.Code, recorder.Body)
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("GET %s: content type %q", path, got)
+	}
+
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(recorder.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
+
+func TestSearchStream(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	cases := []struct {
+		query string
+		meet  bool // the bidirectional sides must meet
+	}{
+		{"algorithm=BFS&searchMode=single", false},
+		{"algorithm=DFS&searchMode=single", false},
+		{"algorithm=bidirectional&searchMode=single", true},
+		{"algorithm=BFS&searchMode=multiple&maxRecipes=3", false},
+		{"algorithm=DFS&searchMode=multiple&maxRecipes=3", false},
+		{"algorithm=bidirectional&searchMode=multiple&maxRecipes=3", true},
+		{"algorithm=Cost", false},
+	}
+	for _, c := range cases {
+		// twice: the second search is cached for /api/search, not for the stream
+		for run := 0; run < 2; run++ {
+			events := streamEvents(t, "/api/search/stream?target=brick&"+c.query)
+			if len(events) < 3 || events[0].name != "start" || events[len(events)-1].name != "done" {
+				t.Fatalf("%s: want start ... done, got %v", c.query, events)
+			}
+
+			explored, trees, seq := 0, 0, 0
+			met := false
+			var result SearchResponseV2
+			for _, e := range events[1 : len(events)-1] {
+				switch e.name {
+				case "result":
+					if err := json.Unmarshal([]byte(e.data), &result); err != nil {
+						t.Fatalf("%s: %v", c.query, err)
+					}
+					continue
+				case "error":
+					t.Fatalf("%s: %s", c.query, e.data)
+				}
+				var event SearchEvent
+				if err := json.Unmarshal([]byte(e.data), &event); err != nil {
+					t.Fatalf("%s: %v", c.query, err)
+				}
+				if event.Type != e.name || event.Seq <= seq {
+					t.Errorf("%s: event %s %+v out of order after seq %d", c.query, e.name, event, seq)
+				}
+				seq = event.Seq
+				switch event.Type {
+				case eventTree:
+					trees++
+					if event.Tree == nil || event.Tree.Name != "Brick" {
+						t.Errorf("%s: tree event %+v", c.query, event)
+					}
+				case eventMeet:
+					met = true
+					explored++
+				default:
+					explored++
+				}
+			}
+			if explored == 0 {
+				t.Errorf("%s: no exploration events", c.query)
+			}
+			if c.meet && !met {
+				t.Errorf("%s: no meet event", c.query)
+			}
+			if trees == 0 || trees != len(result.Trees) {
+				t.Errorf("%s: %d tree events for %d trees", c.query, trees, len(result.Trees))
+			}
+			if result.Stats.Cache != "miss" {
+				t.Errorf("%s: cache %s, a stream never answers from the cache", c.query, result.Stats.Cache)
+			}
+		}
+	}
+}
+
+func TestSearchStreamLimits(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	start := time.Now()
+	events := streamEvents(t, "/api/search/stream?target=House&algorithm=BFS&searchMode=single&maxEvents=4&throttle=5")
+	if elapsed := time.Since(start); elapsed < 4*5*time.Millisecond {
+		t.Errorf("4 events throttled to 5ms took %s", elapsed)
+	}
+	explored := 0
+	for _, e := range events {
+		switch e.name {
+		case eventVisit, eventPush, eventPop:
+			explored++
+		}
+	}
+	var done StreamDone
+	if err := json.Unmarshal([]byte(events[len(events)-1].data), &done); err != nil {
+		t.Fatal(err)
+	}
+	if explored != 4 || done.Dropped == 0 || done.Events != 5 {
+		t.Errorf("maxEvents=4: sent %d exploration events, done %+v", explored, done)
+	}
+
+	events = streamEvents(t, "/api/search/stream?target=Steem&algorithm=BFS")
+	if len(events) != 3 || events[1].name != "error" {
+		t.Fatalf("unknown target: got %v", events)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal([]byte(events[1].data), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != errUnknownElement || len(resp.Suggestions) != 1 || resp.Suggestions[0] != "Steam" {
+		t.Errorf("unknown target: got %+v", resp)
+	}
+}
+
+// TestDFSStreamSkipsCycles checks that single DFS only reports a visit for an
+// element it goes on to expand, not for one already on its stack. Phoenix is
+// made of itself and Fire.
+func TestDFSStreamSkipsCycles(t *testing.T) {
+	loadDataset(t, "testdata/fixture.json")
+
+	for _, target := range []string{"Phoenix", "Chicken"} {
+		var events []SearchEvent
+		searchDFSOne(target, SearchOptions{events: func(event SearchEvent) { events = append(events, event) }})
+
+		onStack := make(map[string]int)
+		for _, event := range events {
+			switch event.Type {
+			case eventVisit:
+				if onStack[event.Element] > 0 {
+					t.Errorf("%s: visit of %s, which is already on the stack", target, event.Element)
+				}
+			case eventPush:
+				onStack[event.Element]++
+			case eventPop:
+				onStack[event.Element]--
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*** SOLUTION TREE VERIFIER ***/
+
+// debugMode verifies every tree before it is returned, see SEARCH_DEBUG.
+var debugMode bool
+
+// TreeProblem is one thing wrong with a recipe tree. Path runs from the root
+// to the offending node, e.g. "Brick/Mud".
+type TreeProblem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// verifyTree checks that every internal node has exactly two children forming
+// a real recipe of the node, that every leaf is a leaf under opts (a base
+// element or an owned one) and that no element is its own ancestor. It returns
+// every problem found, none for a valid tree.
+func verifyTree(root *TreeNode, opts SearchOptions) []TreeProblem {
+	if root == nil {
+		return []TreeProblem{{Message: "empty tree"}}
+	}
+	var problems []TreeProblem
+	var path []string
+	ancestors := make(map[string]bool)
+
+	var walk func(node *TreeNode)
+	walk = func(node *TreeNode) {
+		path = append(path, node.Name)
+		defer func() { path = path[:len(path)-1] }()
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, TreeProblem{Path: strings.Join(path, "/"), Message: fmt.Sprintf(format, args...)})
+		}
+
+		if ancestors[node.Name] {
+			report("%s is its own ancestor", node.Name)
+			return
+		}
+		if len(node.Children) == 0 {
+			if !opts.isLeaf(node.Name) {
+				report("leaf %s is not a base or owned element", node.Name)
+			}
+			return
+		}
+		if len(node.Children) != 2 || node.Children[0] == nil || node.Children[1] == nil {
+			report("%s has %d children, a recipe has 2", node.Name, len(node.Children))
+			return
+		}
+
+		a, b := node.Children[0].Name, node.Children[1].Name
+		if info := lookupElement(node.Name); info == nil {
+			report("unknown element %s", node.Name)
+		} else if findRecipe(info.Recipes, a, b) == nil {
+			report("%s + %s is not a recipe for %s", a, b, node.Name)
+		}
+
+		ancestors[node.Name] = true
+		walk(node.Children[0])
+		walk(node.Children[1])
+		delete(ancestors, node.Name)
+	}
+	walk(root)
+	return problems
+}
+
+// verifyResult runs verifyTree on every tree of a search result.
+func verifyResult(target string, result searchResult, opts SearchOptions) error {
+	for i, tree := range result.trees {
+		problems := verifyTree(tree, opts)
+		for _, problem := range problems {
+			log.Printf("Invalid tree %d for %s at %s: %s\n", i, target, problem.Path, problem.Message)
+		}
+		if len(problems) > 0 {
+			return &SearchError{Code: errInvalidTree, Element: target,
+				Message: fmt.Sprintf("tree %d for %s is invalid at %s: %s", i, target, problems[0].Path, problems[0].Message)}
+		}
+	}
+	return nil
+}
+
+type VerifyRequest struct {
+	Trees     []*TreeNode `json:"trees"`
+	Inventory []string    `json:"inventory"` // owned elements allowed as leaves
+}
+
+type TreeVerification struct {
+	Valid    bool          `json:"valid"`
+	Problems []TreeProblem `json:"problems"`
+}
+
+type VerifyResponse struct {
+	Valid   bool               `json:"valid"` // every tree is valid
+	Results []TreeVerification `json:"results"`
+}
+
+// verifyHandler serves POST /api/verify, checking client-submitted trees.
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, "POST, OPTIONS")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid input"}`, http.StatusBadRequest)
+		log.Printf("Failed to decode verify request: %v\n", err)
+		return
+	}
+	if len(req.Trees) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "trees must not be empty"})
+		return
+	}
+
+	opts := SearchOptions{Inventory: toElementSet(req.Inventory)}
+	resp := VerifyResponse{Valid: true}
+	for _, tree := range req.Trees {
+		problems := verifyTree(tree, opts)
+		if problems == nil {
+			problems = []TreeProblem{}
+		}
+		resp.Results = append(resp.Results, TreeVerification{Valid: len(problems) == 0, Problems: problems})
+		resp.Valid = resp.Valid && len(problems) == 0
+	}
+	writeJSON(w, http.StatusOK, resp)
+}